@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -30,47 +33,125 @@ var AddCmd = &cobra.Command{
 	Example: strings.Join([]string{
 		"$ gopm add lodash",
 		"$ gopm add react react-dom",
+		"$ gopm add react@17 typescript@next lodash@~4.17.0",
+		"$ gopm add preact-compat@npm:preact@10",
+		"$ gopm add --save-exact lodash",
+		"$ gopm add --save-optional fsevents",
+		"$ gopm add --save-peer react",
+		"$ gopm add --no-save left-pad",
+		"$ gopm add -w @acme/web react",
+		"$ gopm add github:lodash/lodash#semver:^4",
+		"$ gopm add file:../my-lib",
+		"$ gopm add --force lodahs",
 	}, "\n"),
 	Run: func(cmd *cobra.Command, args []string) {
-		start := time.Now()
-		exists, err := pkg.VerifyJsonFile()
-		if err != nil || !exists {
-			logrus.Errorln("package.json file not found. Run 'gopm init' or 'gopm init my-module' to create one")
-			os.Exit(1)
-		}
-		if err := pkg.CreateNodeModulesFolder(); err != nil {
-			logrus.Errorln("failed to create node_modules folder")
-			os.Exit(1)
-		}
-		if err := fetchDependencies(args); err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-		fmt.Printf("🍺 Dependencies added successfully in %s\n\n", time.Since(start))
+		runAdd(cmd, args, addSection(cmd))
 	},
 }
 
-// fetchDependencies fetches dependencies from the npm registry
-func fetchDependencies(args []string) error {
+// runAdd adds dependencies to a section of package.json and installs them.
+func runAdd(cmd *cobra.Command, args []string, section string) {
+	start := time.Now()
+	exists, err := pkg.VerifyJsonFile()
+	if err != nil || !exists {
+		logrus.Errorln("package.json file not found. Run 'gopm init' or 'gopm init my-module' to create one")
+		os.Exit(1)
+	}
+	if err := pkg.CreateNodeModulesFolder(); err != nil {
+		logrus.Errorln("failed to create node_modules folder")
+		os.Exit(1)
+	}
+	workspace, _ := cmd.Flags().GetString("workspace")
+	noSave, _ := cmd.Flags().GetBool("no-save")
+	force, _ := cmd.Flags().GetBool("force")
+	if err := fetchDependencies(cmd.Context(), args, workspace, section, noSave, force, saveConfig(cmd)); err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("🍺 Dependencies added successfully in %s\n\n", time.Since(start))
+}
+
+// addSection returns the section of package.json selected by the flags of gopm add.
+func addSection(cmd *cobra.Command) string {
+	for flag, section := range map[string]string{
+		"save-dev":      pkg.DEV_DEPENDENCIES,
+		"save-optional": pkg.OPTIONAL_DEPENDENCIES,
+		"save-peer":     pkg.PEER_DEPENDENCIES,
+	} {
+		if set, _ := cmd.Flags().GetBool(flag); set {
+			return section
+		}
+	}
+	return pkg.DEPENDENCIES
+}
+
+// fetchDependencies resolves dependencies, saves them in a section of the root or
+// workspace package.json and installs the dependency graph. With noSave, neither
+// package.json nor the lockfile are written. With force, names close to a popular
+// package are added anyway.
+func fetchDependencies(ctx context.Context, args []string, workspace, section string, noSave, force bool, config pkg.Config) error {
 	logrus.Infof("Ready to download %d dependencies\n\n", len(args))
-	cwd := pkg.GetCwd()
-	packageJsonPath := filepath.Join(cwd, pkg.PACKAGE_JSON)
 
-	// Open package.json file in read mode
-	file, err := os.Open(packageJsonPath)
+	// package.json is read and written back while holding the lock of the project
+	projectLock, err := pkg.LockProject(ctx, config)
 	if err != nil {
-		return fmt.Errorf("error opening file: %w", err)
+		return err
 	}
-	defer file.Close()
+	defer projectLock.Release()
 
-	// Stream JSON decoding (avoids full memory load)
-	var packageJson pkg.PackageJSON
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&packageJson); err != nil {
+	// Read the root package.json along with its workspaces
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
 		return fmt.Errorf("error decoding package.json: %w", err)
 	}
+	workspaces, err := root.FindWorkspaces()
+	if err != nil {
+		return err
+	}
 
-	// Create errgroup to limit concurrent downloads
+	// Dependencies are added to the root package.json or to the one of a workspace
+	packageJson, packageJsonPath := &root, filepath.Join(pkg.GetCwd(), pkg.PACKAGE_JSON)
+	if workspace != "" {
+		w, err := pkg.FindWorkspace(workspaces, workspace)
+		if err != nil {
+			return err
+		}
+		packageJson, packageJsonPath = w.Manifest, filepath.Join(pkg.GetCwd(), w.Dir, pkg.PACKAGE_JSON)
+	}
+
+	// Check every dependency against the package policy before downloading any
+	policy, err := pkg.LoadPolicy(config)
+	if err != nil {
+		return err
+	}
+	for _, dependency := range args {
+		if w, err := pkg.FindWorkspace(workspaces, dependency); err == nil && w.Manifest.Name == dependency {
+			continue
+		}
+		if err := checkPolicy(policy, dependency, force); err != nil {
+			return err
+		}
+	}
+
+	prefix, err := config.SavePrefix()
+	if err != nil {
+		return err
+	}
+	store, err := pkg.OpenStore(config)
+	if err != nil {
+		return err
+	}
+	storeLock, err := store.Lock(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer storeLock.Release()
+	// Versions are picked like install does, following minimum-release-age
+	resolver := pkg.NewResolver(nil)
+	if err := resolver.Configure(config); err != nil {
+		return err
+	}
+
+	// Create errgroup to limit concurrent requests
 	g := errgroup.Group{}
 	if len(args) > pkg.MAX_CONCURRENT_DOWNLOADS {
 		g.SetLimit(pkg.MAX_CONCURRENT_DOWNLOADS)
@@ -80,28 +161,34 @@ func fetchDependencies(args []string) error {
 
 	var mu sync.Mutex // Protect concurrent writes
 	var added atomic.Bool
+	failures := []error{}
+	fail := func(err error) error {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+		return nil
+	}
 
-	// Download dependencies concurrently
+	// Resolve the latest version of dependencies concurrently, reporting every failure
 	for _, dependency := range args {
-		body := pkg.BodyRegistery{}
 		g.Go(func() error {
-			// Get the latest version of the dependency
-			version, err := body.GetDependencyLatest(dependency)
-			if err != nil || version == "" {
-				return err
+			name, specifier := dependency, pkg.WORKSPACE_PROTOCOL+"*"
+			// Workspace packages are linked rather than downloaded
+			if w, err := pkg.FindWorkspace(workspaces, dependency); err != nil || w.Manifest.Name != dependency {
+				// Get the requested version or the source of the dependency
+				if name, specifier, err = resolveArgument(ctx, store, resolver, dependency, prefix); err != nil {
+					return fail(err)
+				} else if specifier == "" {
+					return nil
+				}
+				// The name of git, tarball and local dependencies is only known once fetched
+				if err := policy.CheckName(name); err != nil {
+					return fail(err)
+				}
 			}
-			// Download the package
-			if !body.DownloadPackage(dependency, version) {
-				return err
-			}
-			// Unzip the downloaded
-			dependencyPath := filepath.Join(cwd, pkg.NODE_MODULE, dependency)
-			if err := pkg.UnzipDependency(dependencyPath); err != nil {
-				return err
-			}
-			// Add dependency to package.json
+			// Add dependency to package.json, moving it from any other section
 			mu.Lock()
-			packageJson.AddDependency(map[string]string{dependency: version})
+			packageJson.SaveDependency(section, name, specifier)
 			added.Store(true) // Atomic write
 			mu.Unlock()
 			return nil
@@ -109,15 +196,37 @@ func fetchDependencies(args []string) error {
 	}
 
 	// Wait for all goroutines to finish
-	if err := g.Wait(); err != nil {
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	if len(failures) > 0 {
+		slices.SortFunc(failures, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+		return errors.Join(failures...)
+	}
 
 	if !added.Load() {
 		logrus.Infoln("❌ No dependencies added. Skipping file write.")
 		return nil
 	}
 
+	// Install the whole dependency graph before saving package.json, rolling both back on failure
+	return withTransaction(ctx, func(tx *pkg.Transaction) error {
+		if err := installDependencies(ctx, &root, workspaces, installOptions{noSave: noSave, tx: tx}); err != nil {
+			return err
+		}
+		if noSave {
+			return nil
+		}
+		if err := tx.Replace(packageJsonPath); err != nil {
+			return err
+		}
+		return writePackageJson(packageJson, packageJsonPath)
+	})
+}
+
+// writePackageJson writes a package.json file atomically.
+func writePackageJson(packageJson *pkg.PackageJSON, packageJsonPath string) error {
 	// Write updated dependencies to a temporary file
 	tempFilePath := packageJsonPath + ".tmp"
 	tempFile, err := os.OpenFile(tempFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
@@ -133,7 +242,7 @@ func fetchDependencies(args []string) error {
 	encoder := json.NewEncoder(tempFile)
 	encoder.SetEscapeHTML(false)
 	encoder.SetIndent("", pkg.INDENT)
-	if err := encoder.Encode(&packageJson); err != nil {
+	if err := encoder.Encode(packageJson); err != nil {
 		return fmt.Errorf("error encoding package.json: %w", err)
 	}
 
@@ -143,3 +252,83 @@ func fetchDependencies(args []string) error {
 	}
 	return nil
 }
+
+// resolveArgument resolves an argument of gopm add or gopm dev to the name and
+// specifier saved in package.json. Registry packages are resolved against the
+// requested version, range or dist-tag and saved with prefix, other dependencies
+// are fetched to learn their name.
+func resolveArgument(ctx context.Context, store *pkg.Store, resolver *pkg.Resolver, arg, prefix string) (string, string, error) {
+	spec, err := pkg.ParseSpec(arg)
+	if err != nil {
+		return "", "", err
+	}
+	if spec.Type != pkg.SPEC_REGISTRY {
+		p, _, err := store.FetchSpec(ctx, spec)
+		if err != nil {
+			return "", "", err
+		}
+		if p.Name == "" {
+			return "", "", fmt.Errorf("%s has no name in its package.json", arg)
+		}
+		return p.Name, spec.Raw, nil
+	}
+
+	version, err := resolver.PickVersion(ctx, spec.Package, spec.Range)
+	if err != nil {
+		return "", "", err
+	}
+	return spec.Name, spec.SaveSpecifier(version, prefix), nil
+}
+
+// checkPolicy checks an argument of gopm add or gopm dev against the package policy.
+// Registry packages named like a popular package with a typo fail unless force is set.
+func checkPolicy(policy pkg.Policy, arg string, force bool) error {
+	spec, err := pkg.ParseSpec(arg)
+	if err != nil {
+		return err
+	}
+	if err := policy.CheckSpec(spec); err != nil {
+		return err
+	}
+	if spec.Type != pkg.SPEC_REGISTRY {
+		return nil
+	}
+	popular := policy.Typosquat(spec.Package)
+	if popular == "" {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("%s looks like a typo of the popular package %s, check the name or use --force to add it anyway", spec.Package, popular)
+	}
+	logrus.Warnf("%s looks like a typo of the popular package %s, adding it anyway", spec.Package, popular)
+	return nil
+}
+
+// saveConfig loads the configuration with the save flags of a command applied.
+func saveConfig(cmd *cobra.Command) pkg.Config {
+	config := pkg.LoadConfig()
+	if exact, _ := cmd.Flags().GetBool("save-exact"); exact {
+		config["save-exact"] = "true"
+	}
+	if cmd.Flags().Changed("save-prefix") {
+		config["save-prefix"], _ = cmd.Flags().GetString("save-prefix")
+	}
+	return config
+}
+
+// addSaveFlags registers the flags controlling where and how dependencies are saved in package.json.
+func addSaveFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("workspace", "w", "", "Add the dependencies to the workspace with this name or directory")
+	cmd.Flags().Bool("no-save", false, "Install the dependencies without saving them in package.json")
+	cmd.Flags().BoolP("save-exact", "E", false, "Save exact versions rather than ranges")
+	cmd.Flags().String("save-prefix", pkg.SAVE_PREFIX_CARET, "Range operator prefixed to saved versions (^ or ~)")
+}
+
+func init() {
+	AddCmd.Flags().BoolP("save-dev", "D", false, "Save the dependencies in devDependencies")
+	AddCmd.Flags().BoolP("save-optional", "O", false, "Save the dependencies in optionalDependencies")
+	AddCmd.Flags().Bool("save-peer", false, "Save the dependencies in peerDependencies")
+	AddCmd.MarkFlagsMutuallyExclusive("save-dev", "save-optional", "save-peer")
+	AddCmd.Flags().BoolP("force", "f", false, "Add packages named like a popular package")
+	addSaveFlags(AddCmd)
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// AuditCmd represents the audit command
+var AuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Report known vulnerabilities of installed packages",
+	Long:  "Check the packages of gopm-lock.json against the advisory database of the registry and report the vulnerabilities with the paths leading to them",
+	Args:  cobra.NoArgs,
+	Example: strings.Join([]string{
+		"$ gopm audit",
+		"$ gopm audit --audit-level=high",
+		"$ gopm audit --json --omit=dev",
+		"$ gopm audit --advisories=advisories.json",
+		"$ gopm audit fix",
+		"$ gopm audit signatures --provenance",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitFailed(auditDependencies(cmd, false))
+	},
+}
+
+// auditFixCmd represents the audit fix command
+var auditFixCmd = &cobra.Command{
+	Use:   "fix",
+	Short: "Upgrade vulnerable packages within the ranges of package.json",
+	Long:  "Resolve the dependency graph again, avoiding vulnerable versions when a safe version satisfies the same range, then install it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitFailed(auditDependencies(cmd, true))
+	},
+}
+
+// auditSignaturesCmd represents the audit signatures command
+var auditSignaturesCmd = &cobra.Command{
+	Use:   "signatures",
+	Short: "Verify the registry signatures of installed packages",
+	Long:  "Verify the ECDSA signatures the registry published for the packages of gopm-lock.json against its public keys, and optionally their provenance attestations",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitFailed(auditSignatures(cmd))
+	},
+}
+
+// auditConfig returns the configuration with the --registry flag applied.
+func auditConfig(cmd *cobra.Command) pkg.Config {
+	config := pkg.LoadConfig()
+	if cmd.Flags().Changed("registry") {
+		config["registry"], _ = cmd.Flags().GetString("registry")
+	}
+	return config
+}
+
+// loadAdvisories reads the advisories file given with --advisories, or sends
+// the packages of the lockfile to the bulk advisory endpoint of the registry.
+func loadAdvisories(cmd *cobra.Command, lock *pkg.Lockfile) (pkg.Advisories, error) {
+	if path, _ := cmd.Flags().GetString("advisories"); path != "" {
+		return pkg.ReadAdvisories(path)
+	}
+	return pkg.FetchAdvisories(cmd.Context(), auditConfig(cmd).Registry(), lock.AuditRequest())
+}
+
+// auditDependencies audits the lockfile, after fixing it when fix is set. It
+// returns whether vulnerabilities of the audit level or higher remain.
+func auditDependencies(cmd *cobra.Command, fix bool) (bool, error) {
+	config := pkg.LoadConfig()
+	level, _ := cmd.Flags().GetString("audit-level")
+	if !cmd.Flags().Changed("audit-level") {
+		level = config.Get("audit-level", level)
+	}
+	level, err := pkg.ParseSeverity(level)
+	if err != nil {
+		return false, err
+	}
+	omit, err := installOmit(cmd)
+	if err != nil {
+		return false, err
+	}
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		return false, fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+	advisories, err := loadAdvisories(cmd, lock.Omit(omit))
+	if err != nil {
+		return false, err
+	}
+	report := lock.Omit(omit).Audit(advisories)
+
+	if fix && len(report.Vulnerabilities) > 0 {
+		projectLock, err := pkg.LockProject(cmd.Context(), pkg.LoadConfig())
+		if err != nil {
+			return false, err
+		}
+		defer projectLock.Release()
+		var root pkg.PackageJSON
+		if _, err := root.ReadPackageJson(); err != nil {
+			return false, err
+		}
+		workspaces, err := root.FindWorkspaces()
+		if err != nil {
+			return false, err
+		}
+		if err := pkg.CreateNodeModulesFolder(); err != nil {
+			return false, err
+		}
+		if err := withTransaction(cmd.Context(), func(tx *pkg.Transaction) error {
+			return installDependencies(cmd.Context(), &root, workspaces, installOptions{omit: omit, avoid: advisories, tx: tx})
+		}); err != nil {
+			return false, err
+		}
+		if lock, err = pkg.ReadLockfile(); err != nil {
+			return false, err
+		}
+		// Newly resolved versions may be affected by advisories of their own
+		if advisories, err = loadAdvisories(cmd, lock.Omit(omit)); err != nil {
+			return false, err
+		}
+		before := len(report.Vulnerabilities)
+		report = lock.Omit(omit).Audit(advisories)
+		if !asJSON {
+			fmt.Printf("🔧 Fixed %d of %d vulnerabilities\n\n", max(before-len(report.Vulnerabilities), 0), before)
+		}
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", pkg.INDENT)
+		return report.AtLeast(level) > 0, encoder.Encode(report)
+	}
+	printAudit(report, fix)
+	return report.AtLeast(level) > 0, nil
+}
+
+// printAudit prints the vulnerabilities of a report followed by their count by severity.
+func printAudit(report *pkg.AuditReport, fixed bool) {
+	for _, v := range report.Vulnerabilities {
+		fmt.Printf("%s  %s\n", strings.ToUpper(v.Advisory.Severity), v.Advisory.Title)
+		fmt.Printf("  Package:    %s\n", v.Package)
+		fmt.Printf("  Vulnerable: %s\n", v.Advisory.VulnerableVersions)
+		if v.Advisory.URL != "" {
+			fmt.Printf("  More info:  %s\n", v.Advisory.URL)
+		}
+		for _, path := range v.Paths {
+			fmt.Printf("  Path:       %s\n", path)
+		}
+		fmt.Println()
+	}
+
+	if len(report.Vulnerabilities) == 0 {
+		fmt.Printf("✅ Found 0 vulnerabilities in %d packages\n", report.Dependencies)
+		return
+	}
+	counts := []string{}
+	for _, severity := range pkg.SEVERITIES {
+		if report.Counts[severity] > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", report.Counts[severity], severity))
+		}
+	}
+	fmt.Printf("🚨 Found %d vulnerabilities in %d packages (%s)\n", len(report.Vulnerabilities), report.Dependencies, strings.Join(counts, ", "))
+	if !fixed {
+		fmt.Println("Run 'gopm audit fix' to upgrade the vulnerable packages within the ranges of package.json")
+	}
+}
+
+// auditSignatures verifies the signatures of the lockfile packages. It returns
+// whether a package failed verification.
+func auditSignatures(cmd *cobra.Command) (bool, error) {
+	omit, err := installOmit(cmd)
+	if err != nil {
+		return false, err
+	}
+	asJSON, _ := cmd.Flags().GetBool("json")
+	provenance, _ := cmd.Flags().GetBool("provenance")
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		return false, fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+
+	config := auditConfig(cmd)
+	if cmd.Flags().Changed("keys") {
+		config["registry-keys"], _ = cmd.Flags().GetString("keys")
+	}
+	resolver := pkg.NewResolver(nil)
+	resolver.Registry = config.Registry()
+	results, err := verifySignatures(cmd.Context(), lock.Omit(omit), config, resolver.Packument, provenance)
+	if err != nil {
+		return false, err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Problem() != "" {
+			failed++
+		}
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", pkg.INDENT)
+		return failed > 0, encoder.Encode(results)
+	}
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+		if result.Provenance != "" {
+			counts["provenance "+result.Provenance]++
+		}
+		if problem := result.Problem(); problem != "" {
+			fmt.Printf("%s  %s\n", result.Package, problem)
+		}
+	}
+	if failed > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("🔏 %d packages have verified registry signatures\n", counts[pkg.SIGNATURE_VERIFIED])
+	if provenance {
+		fmt.Printf("🔏 %d packages have verified attestations, %d have none\n", counts["provenance "+pkg.SIGNATURE_VERIFIED], counts["provenance "+pkg.SIGNATURE_MISSING])
+	}
+	if failed > 0 {
+		fmt.Printf("🚨 %d packages have missing or invalid signatures\n", failed)
+	}
+	return failed > 0, nil
+}
+
+// verifySignatures verifies the packages of a lockfile against the keys of the registry.
+func verifySignatures(ctx context.Context, lock *pkg.Lockfile, config pkg.Config, fetch func(context.Context, string) (*pkg.BodyRegistery, error), provenance bool) ([]pkg.SignatureResult, error) {
+	keys, err := pkg.LoadKeys(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No signing keys found for %s", config.Registry())
+	}
+	return lock.VerifySignatures(ctx, fetch, keys, provenance)
+}
+
+func init() {
+	AuditCmd.PersistentFlags().String("audit-level", pkg.SEVERITY_INFO, "Minimum severity failing the audit: info, low, moderate, high or critical")
+	AuditCmd.PersistentFlags().Bool("json", false, "Print the report as JSON")
+	AuditCmd.PersistentFlags().String("registry", pkg.NPM_REGISTRY, "Registry serving advisories and signing keys")
+	AuditCmd.PersistentFlags().String("advisories", "", "Read advisories from a file in the format of the bulk advisory endpoint instead of the registry")
+	AuditCmd.PersistentFlags().StringSlice("omit", nil, "Dependency types to leave out of the audit: dev, optional or peer")
+	AuditCmd.PersistentFlags().Bool("production", false, "Leave devDependencies out of the audit, same as --omit=dev")
+	auditSignaturesCmd.Flags().String("keys", "", "Read the registry keys from a file in the format of the keys endpoint")
+	auditSignaturesCmd.Flags().Bool("provenance", false, "Also verify the attestations of packages published with provenance")
+	AuditCmd.AddCommand(auditFixCmd, auditSignaturesCmd)
+}
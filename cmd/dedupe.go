@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// DedupeCmd represents the dedupe command
+var DedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Reduce duplicate packages",
+	Long:  "Resolve every package to the fewest versions satisfying all the ranges requiring it, then rewrite node_modules and the lockfile",
+	Args:  cobra.NoArgs,
+	Example: strings.Join([]string{
+		"$ gopm dedupe",
+		"$ gopm dedupe --dry-run",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if err := dedupeDependencies(cmd.Context(), dryRun); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// dedupeDependencies dedupes the lockfile and installs the resulting graph,
+// removing the package folders which are no longer part of the layout.
+func dedupeDependencies(ctx context.Context, dryRun bool) error {
+	config := pkg.LoadConfig()
+	if !dryRun {
+		projectLock, err := pkg.LockProject(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer projectLock.Release()
+	}
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return err
+	}
+	workspaces, err := root.FindWorkspaces()
+	if err != nil {
+		return err
+	}
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+
+	store, err := pkg.OpenStore(config)
+	if err != nil {
+		return err
+	}
+	storeLock, err := store.Lock(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer storeLock.Release()
+	linker := config.Get("node-linker", pkg.LINKER_HOISTED)
+	deduped := lock.Dedupe()
+	before, _, err := pkg.PlanLayout(lock, linker)
+	if err != nil {
+		return err
+	}
+	after, _, err := pkg.PlanLayout(deduped, linker)
+	if err != nil {
+		return err
+	}
+
+	removed := []string{}
+	for _, key := range slices.Sorted(maps.Keys(lock.Packages)) {
+		if _, ok := deduped.Packages[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	saved := layoutSize(store, lock, before) - layoutSize(store, deduped, after)
+	if dryRun {
+		for _, key := range removed {
+			fmt.Printf("would remove %s\n", key)
+		}
+		fmt.Printf("🧹 %d duplicate packages would be removed, saving %.2f MB\n", len(removed), float64(saved)/(1<<20))
+		return nil
+	}
+
+	// Installing removes the duplicates, which are no longer part of the layout
+	if err := withTransaction(ctx, func(tx *pkg.Transaction) error {
+		return installLockfile(ctx, deduped, workspaces, config, store, installOptions{patches: root.PatchedDependencies, tx: tx})
+	}); err != nil {
+		return err
+	}
+	for _, key := range removed {
+		fmt.Printf("removed %s\n", key)
+	}
+	fmt.Printf("🧹 Removed %d duplicate packages, saving %.2f MB\n", len(removed), float64(saved)/(1<<20))
+	return nil
+}
+
+// layoutSize returns the size of the package files installed by a layout.
+func layoutSize(store *pkg.Store, lock *pkg.Lockfile, layout pkg.Layout) int64 {
+	var size int64
+	for _, key := range layout {
+		if p, ok := lock.Packages[key]; ok {
+			if packageSize, ok := store.PackageSize(p); ok {
+				size += packageSize
+			}
+		}
+	}
+	return size
+}
+
+func init() {
+	DedupeCmd.Flags().Bool("dry-run", false, "Only print the packages which would be removed")
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/emmadal/gopm/pkg"
+)
+
+// exitWithError prints the error of a failed command, summing up every failure when
+// several packages failed, and exits with the exit code of its kind of failure.
+func exitWithError(err error) {
+	failures := pkg.Failures(err)
+	if len(failures) < 2 {
+		fmt.Println(err.Error())
+		os.Exit(pkg.ExitCode(err))
+	}
+	fmt.Printf("❌ %d failures:\n", len(failures))
+	for _, f := range failures {
+		kind := f.Kind
+		if kind == "" {
+			kind = "error"
+		}
+		fmt.Printf("  [%s] %s\n", kind, f.Err)
+	}
+	os.Exit(pkg.ExitCode(err))
+}
+
+// exitFailed exits with an error when a command failed or found problems to report.
+func exitFailed(failed bool, err error) {
+	if err != nil {
+		exitWithError(err)
+	}
+	if failed {
+		os.Exit(pkg.EXIT_FAILURE)
+	}
+}
@@ -1,13 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emmadal/gopm/pkg"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 )
 
 // InstallCmd represents the install command
@@ -23,21 +32,46 @@ var InstallCmd = &cobra.Command{
 	},
 	Example: strings.Join([]string{
 		"$ gopm install",
+		"$ gopm install --omit=dev",
+		"$ NODE_ENV=production gopm install",
 	}, "\n"),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := getPackageJson()
+		omit, err := installOmit(cmd)
+		if err == nil {
+			verify, _ := cmd.Flags().GetBool("verify-signatures")
+			err = getPackageJson(cmd.Context(), installOptions{omit: omit, verifySignatures: verify || pkg.LoadConfig().Bool("verify-signatures")})
+		}
 		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
+			exitWithError(err)
 		}
 	},
 }
 
+// installOmit returns the dependency types left out of the install, from the
+// --omit and --production flags, the omit setting and NODE_ENV=production.
+func installOmit(cmd *cobra.Command) ([]string, error) {
+	values, _ := cmd.Flags().GetStringSlice("omit")
+	if omit := pkg.LoadConfig().Get("omit", ""); omit != "" && !cmd.Flags().Changed("omit") {
+		values = append(values, strings.Fields(omit)...)
+	}
+	if production, _ := cmd.Flags().GetBool("production"); production || os.Getenv("NODE_ENV") == "production" {
+		values = append(values, pkg.OMIT_DEV)
+	}
+	return pkg.ParseOmit(values)
+}
+
 // getPackageJson gets the package.json file
-func getPackageJson() error {
+func getPackageJson(ctx context.Context, opts installOptions) error {
 	start := time.Now()
 	var p pkg.PackageJSON
 
+	// Another gopm process changing the project is waited for
+	projectLock, err := pkg.LockProject(ctx, pkg.LoadConfig())
+	if err != nil {
+		return err
+	}
+	defer projectLock.Release()
+
 	fileContent, err := p.ReadPackageJson()
 	if err != nil {
 		return err
@@ -47,33 +81,322 @@ func getPackageJson() error {
 		return err
 	}
 
-	// Process Dependencies
-	if deps, err := extractDependencies(fileContent.Dependencies); err == nil {
-		if err := fetchDependencies(deps); err != nil {
+	workspaces, err := fileContent.FindWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	if err := withTransaction(ctx, func(tx *pkg.Transaction) error {
+		opts.tx = tx
+		return installDependencies(ctx, fileContent, workspaces, opts)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("🍺 Install completed in %v\n", time.Since(start))
+	return nil
+}
+
+// installOptions are the options of an install
+type installOptions struct {
+	// noSave leaves the lockfile untouched
+	noSave bool
+	// omit lists the dependency types left out of node_modules, the lockfile keeps them
+	omit []string
+	// skip lists optional packages left out of node_modules, the lockfile keeps them
+	skip []string
+	// patches maps name@version to the patch applied to the package after install
+	patches map[string]string
+	// reinstall lists packages installed afresh even when already in node_modules
+	reinstall map[string]bool
+	// avoid lists advisories whose vulnerable versions are not resolved when possible
+	avoid pkg.Advisories
+	// verifySignatures verifies the registry signatures of packages before installing them
+	verifySignatures bool
+	// tx records the changes made to the project, rolled back when the install fails
+	tx *pkg.Transaction
+}
+
+// withTransaction runs an install in a transaction, rolling back node_modules,
+// package.json and the lockfile when it fails or ctx is cancelled by Ctrl-C. The
+// caller holds the lock of the project.
+func withTransaction(ctx context.Context, install func(tx *pkg.Transaction) error) error {
+	tx, err := pkg.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		logrus.Warnln("Interrupted, rolling back the install")
+		tx.Interrupt()
+	})
+	defer stop()
+
+	err = install(tx)
+	if ctx.Err() != nil {
+		// An interruption after the last change still rolls back, and downloads
+		// or scripts cut short by it are reported as an interruption
+		err = pkg.ErrInterrupted
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, and rolling back failed: %v", err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// installDependencies resolves the dependency graph of package.json and its workspaces,
+// installs it in node_modules using the configured node-linker and writes the lockfile
+func installDependencies(ctx context.Context, packageJson *pkg.PackageJSON, workspaces []pkg.Workspace, opts installOptions) error {
+	locked, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	config := pkg.LoadConfig()
+	if err := checkPackageManager(packageJson, config); err != nil {
+		return err
+	}
+	store, err := pkg.OpenStore(config)
+	if err != nil {
+		return err
+	}
+	storeLock, err := store.Lock(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer storeLock.Release()
+	// Copied and cloned files are kept by store prune as long as the project uses them
+	if err := store.RegisterProject(pkg.GetCwd()); err != nil {
+		return err
+	}
+	resolver := pkg.NewResolver(locked)
+	resolver.Store = store
+	resolver.Avoid = opts.avoid
+	if err := resolver.Configure(config); err != nil {
+		return err
+	}
+	lock, err := resolver.Resolve(ctx, packageJson, workspaces)
+	if err != nil {
+		return err
+	}
+	installed := lock.Omit(opts.omit)
+	if opts.skip, err = checkEngines(ctx, packageJson, workspaces, installed, config); err != nil {
+		return err
+	}
+	installed = installed.Without(opts.skip)
+	if opts.verifySignatures {
+		results, err := verifySignatures(ctx, installed, config, resolver.Packument, false)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if problem := result.Problem(); problem != "" {
+				return fmt.Errorf("Failed to verify %s: %s", result.Package, problem)
+			}
+		}
+	}
+	opts.patches = packageJson.PatchedDependencies
+	return installLockfile(ctx, lock, workspaces, config, store, opts)
+}
+
+// checkPackageManager checks the packageManager field of package.json. Another
+// package manager fails unless package-manager-strict is false, and another version
+// of gopm fails under engine-strict.
+func checkPackageManager(packageJson *pkg.PackageJSON, config pkg.Config) error {
+	if packageJson.PackageManager == "" {
+		return nil
+	}
+	name, version, err := pkg.ParsePackageManager(packageJson.PackageManager)
+	if err != nil {
+		return err
+	}
+	if name != pkg.PACKAGE_MANAGER {
+		if config.Get("package-manager-strict", "true") != "false" {
+			return fmt.Errorf("This project is configured to use %s (packageManager), set package-manager-strict=false to install it with gopm anyway", packageJson.PackageManager)
+		}
+		logrus.Warnf("This project is configured to use %s (packageManager)", packageJson.PackageManager)
+		return nil
+	}
+	if version != pkg.VERSION {
+		if config.Bool("engine-strict") {
+			return fmt.Errorf("This project requires gopm %s (packageManager) but gopm %s is running", version, pkg.VERSION)
+		}
+		logrus.Warnf("This project requires gopm %s (packageManager) but gopm %s is running", version, pkg.VERSION)
+	}
+	return nil
+}
+
+// checkEngines checks the project, its workspaces and the packages of the lockfile
+// against the node and gopm versions of their engines field. Under engine-strict,
+// mismatches fail the install except for optional packages, which are returned to
+// be skipped.
+func checkEngines(ctx context.Context, packageJson *pkg.PackageJSON, workspaces []pkg.Workspace, lock *pkg.Lockfile, config pkg.Config) ([]string, error) {
+	versions := pkg.EngineVersions(ctx)
+	name := packageJson.Name
+	if name == "" {
+		name = pkg.PACKAGE_JSON
+	}
+	mismatches := packageJson.Engines.Check(name, versions)
+	for _, w := range workspaces {
+		name := w.Manifest.Name
+		if name == "" {
+			name = path.Join(w.Dir, pkg.PACKAGE_JSON)
+		}
+		mismatches = append(mismatches, w.Manifest.Engines.Check(name, versions)...)
+	}
+	mismatches = append(mismatches, lock.CheckEngines(versions)...)
+	if !config.Bool("engine-strict") {
+		for _, mismatch := range mismatches {
+			logrus.Warnf("Unsupported engine: %s", mismatch)
+		}
+		return nil, nil
+	}
+
+	skipped, unsupported := []string{}, []string{}
+	for _, mismatch := range mismatches {
+		if p, ok := lock.Packages[mismatch.Package]; ok && p.Optional {
+			if !slices.Contains(skipped, mismatch.Package) {
+				skipped = append(skipped, mismatch.Package)
+			}
+			logrus.Warnf("Skipping optional dependency %s: unsupported engine: %s", mismatch.Package, mismatch)
+			continue
+		}
+		unsupported = append(unsupported, mismatch.String())
+	}
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("Unsupported engines (engine-strict):\n  %s", strings.Join(unsupported, "\n  "))
+	}
+	return skipped, nil
+}
+
+// installLockfile installs a resolved dependency graph in node_modules using
+// the configured node-linker and writes the lockfile
+func installLockfile(ctx context.Context, lock *pkg.Lockfile, workspaces []pkg.Workspace, config pkg.Config, store *pkg.Store, opts installOptions) error {
+	installed := lock.Omit(opts.omit).Without(opts.skip)
+	layout, links, err := pkg.PlanLayout(installed, config.Get("node-linker", pkg.LINKER_HOISTED))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Ready to install %d packages\n\n", len(layout))
+	patches, err := readPatches(lock, layout, opts.patches)
+	if err != nil {
+		return err
+	}
+
+	// Create errgroup to limit concurrent downloads. Every package is attempted so
+	// that all the failures are reported together.
+	g := errgroup.Group{}
+	g.SetLimit(pkg.MAX_CONCURRENT_DOWNLOADS)
+	var mu sync.Mutex
+	failures := []error{}
+	for key, locations := range layout.Locations() {
+		p := lock.Packages[key]
+		g.Go(func() error {
+			if err := installPackage(ctx, opts.tx, store, p, locations, patches[key], opts.reinstall[key]); err != nil {
+				if p.Optional && ctx.Err() == nil {
+					opts.tx.Unstage(locations...)
+					logrus.Warnf("Skipping optional dependency %s: %v", key, err)
+					return nil
+				}
+				mu.Lock()
+				failures = append(failures, fmt.Errorf("%s: %w", key, err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	// Wait for all goroutines to finish
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		slices.SortFunc(failures, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+		return errors.Join(failures...)
+	}
+	// node_modules only changes once every package is staged
+	if err := opts.tx.Swap(); err != nil {
+		return err
+	}
+	// Packages of the previous layout, such as those nested in a replaced package, would shadow the new ones
+	if _, err := opts.tx.RemoveExtraneous(installed, layout, links); err != nil {
+		return err
+	}
+	if policy := config.LicensePolicy(); policy.Enabled() {
+		if err := checkLicenses(installed, layout, policy); err != nil {
 			return err
 		}
-	} else {
+	}
+	if err := pkg.CreateLinks(links, opts.tx); err != nil {
 		return err
 	}
 
-	// Process Dev Dependencies
-	if devDeps, err := extractDependencies(fileContent.DevDependencies); err == nil {
-		if err := fetchDevDependencies(devDeps); err != nil {
+	// Link executables of direct dependencies afresh
+	cwd := pkg.GetCwd()
+	dirs := []string{cwd}
+	for _, w := range workspaces {
+		dirs = append(dirs, filepath.Join(cwd, w.Dir))
+	}
+	for _, dir := range dirs {
+		if err := opts.tx.Replace(filepath.Join(dir, pkg.NODE_MODULE, pkg.BIN_DIR)); err != nil {
+			return err
+		}
+		if err := pkg.LinkBins(dir); err != nil {
 			return err
 		}
-	} else {
+	}
+	if opts.noSave {
+		return nil
+	}
+	if err := opts.tx.Replace(filepath.Join(cwd, pkg.LOCK_FILE)); err != nil {
 		return err
 	}
+	return lock.Write()
+}
 
-	fmt.Printf("🍺 Install completed in %v\n", time.Since(start))
-	return nil
+// readPatches reads the patches of the packages of a layout by package key.
+func readPatches(lock *pkg.Lockfile, layout pkg.Layout, patchedDependencies map[string]string) (map[string]string, error) {
+	installed := map[string]bool{}
+	for _, key := range layout {
+		installed[key] = true
+	}
+	patches := map[string]string{}
+	for _, key := range slices.Sorted(maps.Keys(patchedDependencies)) {
+		if _, ok := lock.Packages[key]; !ok {
+			logrus.Warnf("Patch %s does not match any package of %s", patchedDependencies[key], pkg.LOCK_FILE)
+			continue
+		}
+		if !installed[key] {
+			continue
+		}
+		patch, err := os.ReadFile(filepath.Join(pkg.GetCwd(), filepath.FromSlash(patchedDependencies[key])))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the patch of %s: %w", key, err)
+		}
+		patches[key] = string(patch)
+	}
+	return patches, nil
 }
 
-// extractDependencies gets the dependencies from the package.json file
-func extractDependencies(dependencies map[string]string) ([]string, error) {
-	deps := make([]string, 0, len(dependencies)) // Preallocate slice capacity
-	for dep := range dependencies {
-		deps = append(deps, dep)
+// installPackage stages a package for its locations and applies its patch.
+// Patched packages are staged afresh so that the patch applies to pristine files.
+func installPackage(ctx context.Context, tx *pkg.Transaction, store *pkg.Store, p *pkg.LockPackage, locations []string, patch string, reinstall bool) error {
+	staged, err := pkg.InstallPackage(ctx, tx, store, p, locations, patch != "" || reinstall)
+	if err != nil || patch == "" {
+		return err
+	}
+	for _, dir := range staged {
+		if err := pkg.ApplyPatch(dir, patch); err != nil {
+			return fmt.Errorf("patch of %s no longer applies, update it with 'gopm patch %s': %w", pkg.PackageKey(p.Name, p.Version), pkg.PackageKey(p.Name, p.Version), err)
+		}
 	}
-	return deps, nil
+	return nil
+}
+
+func init() {
+	InstallCmd.Flags().StringSlice("omit", nil, "Dependency types to leave out of node_modules: dev, optional or peer")
+	InstallCmd.Flags().Bool("production", false, "Leave devDependencies out of node_modules, same as --omit=dev")
+	InstallCmd.Flags().Bool("verify-signatures", false, "Verify the registry signatures of packages before installing them")
 }
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// LicensesCmd represents the licenses command
+var LicensesCmd = &cobra.Command{
+	Use:   "licenses",
+	Short: "Report the licenses of installed packages",
+	Long:  "List the licenses of the packages installed in node_modules grouped by license, read from their package.json and license files, and check them against the license-allow and license-deny settings",
+	Args:  cobra.NoArgs,
+	Example: strings.Join([]string{
+		"$ gopm licenses",
+		"$ gopm licenses --production",
+		"$ gopm licenses --json",
+		"$ gopm licenses --csv > licenses.csv",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		omit, err := installOmit(cmd)
+		if err != nil {
+			exitWithError(err)
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+		asCSV, _ := cmd.Flags().GetBool("csv")
+		exitFailed(reportLicenses(omit, asJSON, asCSV))
+	},
+}
+
+// installedLicenses returns the licenses of the packages installed from the lockfile.
+func installedLicenses(lock *pkg.Lockfile, config pkg.Config, omit []string) ([]pkg.PackageLicense, error) {
+	omitted := lock.Omit(omit)
+	layout, _, err := pkg.PlanLayout(omitted, config.Get("node-linker", pkg.LINKER_HOISTED))
+	if err != nil {
+		return nil, err
+	}
+	return omitted.Licenses(layout)
+}
+
+// checkLicenses fails when a package of the layout has a license the policy does not allow.
+func checkLicenses(lock *pkg.Lockfile, layout pkg.Layout, policy pkg.LicensePolicy) error {
+	licenses, err := lock.Licenses(layout)
+	if err != nil {
+		return err
+	}
+	violations := policy.Violations(licenses)
+	if len(violations) == 0 {
+		return nil
+	}
+	packages := []string{}
+	for _, violation := range violations {
+		packages = append(packages, fmt.Sprintf("%s (%s)", violation.Package, violation.License))
+	}
+	return fmt.Errorf("Packages with disallowed licenses: %s", strings.Join(packages, ", "))
+}
+
+// reportLicenses prints the licenses of the installed packages. It returns
+// whether some of them are not allowed by the license policy.
+func reportLicenses(omit []string, asJSON, asCSV bool) (bool, error) {
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		return false, fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+	config := pkg.LoadConfig()
+	licenses, err := installedLicenses(lock, config, omit)
+	if err != nil {
+		return false, err
+	}
+	policy := config.LicensePolicy()
+	violations := policy.Violations(licenses)
+
+	groups := map[string][]pkg.PackageLicense{}
+	for _, license := range licenses {
+		groups[license.License] = append(groups[license.License], license)
+	}
+	switch {
+	case asJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", pkg.INDENT)
+		return len(violations) > 0, encoder.Encode(groups)
+	case asCSV:
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"license", "package", "name", "version", "allowed", "files"})
+		for _, name := range slices.Sorted(maps.Keys(groups)) {
+			for _, license := range groups[name] {
+				writer.Write([]string{name, license.Package, license.Name, license.Version, fmt.Sprint(policy.Allows(name)), strings.Join(license.Files, " ")})
+			}
+		}
+		writer.Flush()
+		return len(violations) > 0, writer.Error()
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		marker := ""
+		if !policy.Allows(name) {
+			marker = "  🚫 not allowed"
+		}
+		fmt.Printf("%s (%d)%s\n", name, len(groups[name]), marker)
+		for _, license := range groups[name] {
+			fmt.Printf("  %s\n", license.Package)
+		}
+	}
+	fmt.Printf("\n📜 %d packages under %d licenses\n", len(licenses), len(groups))
+	if len(violations) > 0 {
+		fmt.Printf("🚨 %d packages have licenses not allowed by the license policy\n", len(violations))
+	}
+	return len(violations) > 0, nil
+}
+
+func init() {
+	LicensesCmd.Flags().Bool("json", false, "Print the licenses as JSON")
+	LicensesCmd.Flags().Bool("csv", false, "Print the licenses as CSV")
+	LicensesCmd.MarkFlagsMutuallyExclusive("json", "csv")
+	LicensesCmd.Flags().StringSlice("omit", nil, "Dependency types to leave out of the report: dev, optional or peer")
+	LicensesCmd.Flags().Bool("production", false, "Leave devDependencies out of the report, same as --omit=dev")
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// PatchCmd represents the patch command
+var PatchCmd = &cobra.Command{
+	Use:   "patch <package>",
+	Short: "Prepare a package for patching",
+	Long:  "Extract a pristine copy of an installed package to a temporary folder. Edit it, then run 'gopm patch-commit' to save the changes as a patch",
+	Args:  cobra.ExactArgs(1),
+	Example: strings.Join([]string{
+		"$ gopm patch lodash",
+		"$ gopm patch lodash@4.17.21",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := preparePatch(cmd.Context(), args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// PatchCommitCmd represents the patch-commit command
+var PatchCommitCmd = &cobra.Command{
+	Use:   "patch-commit <dir>",
+	Short: "Save the changes made to a package prepared by gopm patch",
+	Long:  "Generate a patch from the folder created by 'gopm patch', store it in the patches folder, reference it from package.json and reinstall",
+	Args:  cobra.ExactArgs(1),
+	Example: strings.Join([]string{
+		"$ gopm patch-commit /tmp/gopm-patch-123456",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := commitPatch(cmd.Context(), args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// patchedPackage returns the package of the lockfile matching a query.
+func patchedPackage(lock *pkg.Lockfile, query string) (string, *pkg.LockPackage, error) {
+	if lock == nil {
+		return "", nil, fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+	keys, err := lock.Match(query)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return "", nil, fmt.Errorf("%s matches several packages, pick a version: %s", query, strings.Join(keys, ", "))
+	}
+	return keys[0], lock.Packages[keys[0]], nil
+}
+
+// preparePatch extracts a package to a temporary folder, with its current patch applied.
+func preparePatch(ctx context.Context, query string) error {
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return err
+	}
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	key, p, err := patchedPackage(lock, query)
+	if err != nil {
+		return err
+	}
+	config := pkg.LoadConfig()
+	store, err := pkg.OpenStore(config)
+	if err != nil {
+		return err
+	}
+	storeLock, err := store.Lock(ctx, config, false)
+	if err != nil {
+		return err
+	}
+	defer storeLock.Release()
+
+	dir, err := os.MkdirTemp("", "gopm-patch-*")
+	if err != nil {
+		return err
+	}
+	if err := store.ExtractPackage(ctx, p, dir); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to extract %s: %w", key, err)
+	}
+	// Start from the current patch so that patch-commit replaces it. A patch which
+	// no longer applies is left out, the package is then patched from scratch.
+	if patchFile, ok := root.PatchedDependencies[key]; ok {
+		patch, err := os.ReadFile(filepath.Join(pkg.GetCwd(), filepath.FromSlash(patchFile)))
+		if err == nil {
+			err = pkg.ApplyPatch(dir, string(patch))
+		}
+		if err != nil {
+			logrus.Warnf("Failed to apply %s, starting from the pristine package: %v", patchFile, err)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			if err := store.ExtractPackage(ctx, p, dir); err != nil {
+				return fmt.Errorf("failed to extract %s: %w", key, err)
+			}
+		}
+	}
+	state := pkg.PatchState{Package: key}
+	if err := state.Write(dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	fmt.Printf("You can now edit %s in:\n\n  %s\n\n", key, dir)
+	fmt.Printf("Once you are done, run:\n\n  gopm patch-commit '%s'\n", dir)
+	return nil
+}
+
+// commitPatch saves the changes made in a folder created by gopm patch and reinstalls.
+func commitPatch(ctx context.Context, dir string) error {
+	state, err := pkg.ReadPatchState(dir)
+	if err != nil {
+		return err
+	}
+	config := pkg.LoadConfig()
+	projectLock, err := pkg.LockProject(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer projectLock.Release()
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return err
+	}
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+	p, ok := lock.Packages[state.Package]
+	if !ok {
+		return fmt.Errorf("%s is no longer part of %s", state.Package, pkg.LOCK_FILE)
+	}
+	store, err := pkg.OpenStore(config)
+	if err != nil {
+		return err
+	}
+	diff, err := store.DiffPackage(p, dir)
+	if err != nil {
+		return err
+	}
+
+	cwd := pkg.GetCwd()
+	patchFile := pkg.PatchFile(p)
+	if previous, ok := root.PatchedDependencies[state.Package]; ok {
+		patchFile = previous
+	}
+	if _, ok := root.PatchedDependencies[state.Package]; !ok && diff == "" {
+		fmt.Printf("No changes made to %s\n", state.Package)
+		return os.RemoveAll(dir)
+	}
+	workspaces, err := root.FindWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	// The patch, package.json and the reinstalled package are rolled back together
+	if err := withTransaction(ctx, func(tx *pkg.Transaction) error {
+		path := filepath.Join(cwd, filepath.FromSlash(patchFile))
+		if err := tx.Replace(path); err != nil {
+			return err
+		}
+		if diff == "" {
+			// Reverting every change removes the patch
+			fmt.Printf("No changes made to %s\n", state.Package)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			delete(root.PatchedDependencies, state.Package)
+		} else {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(diff), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", patchFile, err)
+			}
+			if root.PatchedDependencies == nil {
+				root.PatchedDependencies = map[string]string{}
+			}
+			root.PatchedDependencies[state.Package] = patchFile
+			fmt.Printf("Patch saved to %s\n", patchFile)
+		}
+		packageJsonPath := filepath.Join(cwd, pkg.PACKAGE_JSON)
+		if err := tx.Replace(packageJsonPath); err != nil {
+			return err
+		}
+		if err := writePackageJson(&root, packageJsonPath); err != nil {
+			return err
+		}
+		return installDependencies(ctx, &root, workspaces, installOptions{reinstall: map[string]bool{state.Package: true}, tx: tx})
+	}); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// PruneCmd represents the prune command
+var PruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove extraneous packages",
+	Long:  "Remove the packages of node_modules which are not required by package.json anymore, along with their dangling .bin links",
+	Args:  cobra.NoArgs,
+	Example: strings.Join([]string{
+		"$ gopm prune",
+		"$ gopm prune --dry-run",
+		"$ gopm prune --omit=dev",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		omit, err := installOmit(cmd)
+		if err == nil {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			err = pruneDependencies(cmd.Context(), omit, dryRun)
+		}
+		if err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// pruneDependencies removes the packages of node_modules missing from the
+// layout planned for the lockfile restricted to the declared dependencies.
+func pruneDependencies(ctx context.Context, omit []string, dryRun bool) error {
+	config := pkg.LoadConfig()
+	if !dryRun {
+		projectLock, err := pkg.LockProject(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer projectLock.Release()
+	}
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return err
+	}
+	workspaces, err := root.FindWorkspaces()
+	if err != nil {
+		return err
+	}
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+
+	layout, links, err := pkg.PlanLayout(lock.Retain(&root, workspaces).Omit(omit), config.Get("node-linker", pkg.LINKER_HOISTED))
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		extraneous, err := pkg.PlanPrune(lock, layout, links)
+		if err != nil {
+			return err
+		}
+		for _, location := range extraneous {
+			fmt.Printf("would remove %s\n", location)
+		}
+		fmt.Printf("🧹 %d extraneous entries would be removed\n", len(extraneous))
+		return nil
+	}
+
+	// Extraneous entries are moved into the transaction and removed once it commits
+	var extraneous []string
+	if err := withTransaction(ctx, func(tx *pkg.Transaction) error {
+		extraneous, err = tx.RemoveExtraneous(lock, layout, links)
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, location := range extraneous {
+		fmt.Printf("removed %s\n", location)
+	}
+	fmt.Printf("🧹 Removed %d extraneous entries\n", len(extraneous))
+	return nil
+}
+
+func init() {
+	PruneCmd.Flags().Bool("dry-run", false, "Only print what would be removed")
+	PruneCmd.Flags().StringSlice("omit", nil, "Dependency types to remove from node_modules: dev, optional or peer")
+	PruneCmd.Flags().Bool("production", false, "Remove devDependencies, same as --omit=dev")
+}
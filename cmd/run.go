@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// RunCmd represents the run command
+var RunCmd = &cobra.Command{
+	Use:   "run <script> [args...]",
+	Short: "Run a script defined in package.json",
+	Long:  "Run a script defined in package.json, in the project or in its workspaces",
+	Args:  cobra.MinimumNArgs(1),
+	Example: strings.Join([]string{
+		"$ gopm run build",
+		"$ gopm run test -- --watch",
+		"$ gopm run --workspaces build",
+		"$ gopm run -w @acme/web dev",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("workspaces")
+		workspace, _ := cmd.Flags().GetString("workspace")
+		if err := runScript(cmd.Context(), args[0], args[1:], all, workspace); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// runScript runs a script in the project, in one workspace or in every workspace defining it
+func runScript(ctx context.Context, script string, args []string, all bool, workspace string) error {
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return fmt.Errorf("error reading package.json: %w", err)
+	}
+	if !all && workspace == "" {
+		return pkg.RunScript(ctx, pkg.GetCwd(), &root, script, args)
+	}
+
+	workspaces, err := root.FindWorkspaces()
+	if err != nil {
+		return err
+	}
+	if workspace != "" {
+		w, err := pkg.FindWorkspace(workspaces, workspace)
+		if err != nil {
+			return err
+		}
+		return pkg.RunScript(ctx, filepath.Join(pkg.GetCwd(), w.Dir), w.Manifest, script, args)
+	}
+
+	if len(workspaces) == 0 {
+		return fmt.Errorf("No workspaces found in package.json")
+	}
+	for _, w := range workspaces {
+		if _, ok := w.Manifest.Scripts[script]; !ok {
+			fmt.Printf("Skipping %s: no %s script\n", w.Manifest.Name, script)
+			continue
+		}
+		if err := pkg.RunScript(ctx, filepath.Join(pkg.GetCwd(), w.Dir), w.Manifest, script, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	RunCmd.Flags().SetInterspersed(false)
+	RunCmd.Flags().Bool("workspaces", false, "Run the script in every workspace defining it")
+	RunCmd.Flags().StringP("workspace", "w", "", "Run the script in the workspace with this name or directory")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// SbomCmd represents the sbom command
+var SbomCmd = &cobra.Command{
+	Use:   "sbom",
+	Short: "Generate a software bill of materials",
+	Long:  "Generate a software bill of materials of the dependency graph of gopm-lock.json in the CycloneDX or SPDX JSON format, with the purl, integrity hashes, license and dependencies of every package",
+	Args:  cobra.NoArgs,
+	Example: strings.Join([]string{
+		"$ gopm sbom",
+		"$ gopm sbom --format spdx",
+		"$ gopm sbom --production -o sbom.cdx.json",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		omit, err := installOmit(cmd)
+		if err != nil {
+			exitWithError(err)
+		}
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		if err := writeSBOM(format, output, omit); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// writeSBOM writes the bill of materials of the project in a format to a file,
+// or to the standard output when output is empty.
+func writeSBOM(format, output string, omit []string) error {
+	var root pkg.PackageJSON
+	if _, err := root.ReadPackageJson(); err != nil {
+		return fmt.Errorf("error decoding package.json: %w", err)
+	}
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+	// Licenses are read from node_modules and left out for packages not installed
+	licenses, err := installedLicenses(lock, pkg.LoadConfig(), omit)
+	if err != nil {
+		return err
+	}
+	sbom := lock.Omit(omit).SBOM(&root, licenses)
+
+	var document any
+	switch strings.ToLower(format) {
+	case pkg.SBOM_CYCLONEDX:
+		document = sbom.CycloneDX()
+	case pkg.SBOM_SPDX:
+		document = sbom.SPDX()
+	default:
+		return fmt.Errorf("unknown SBOM format %q, expected cyclonedx or spdx", format)
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", pkg.INDENT)
+	return encoder.Encode(document)
+}
+
+func init() {
+	SbomCmd.Flags().String("format", pkg.SBOM_CYCLONEDX, "Format of the bill of materials: cyclonedx or spdx")
+	SbomCmd.Flags().StringP("output", "o", "", "Write the bill of materials to a file instead of the standard output")
+	SbomCmd.Flags().StringSlice("omit", nil, "Dependency types to leave out of the bill of materials: dev, optional or peer")
+	SbomCmd.Flags().Bool("production", false, "Leave devDependencies out of the bill of materials, same as --omit=dev")
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// StoreCmd represents the store command
+var StoreCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the package store",
+	Long:  "Manage the content-addressable store shared by every project to populate node_modules",
+	Example: strings.Join([]string{
+		"$ gopm store path",
+		"$ gopm store prune",
+	}, "\n"),
+}
+
+// storePathCmd represents the store path command
+var storePathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the store",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := pkg.OpenStore(pkg.LoadConfig())
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(store.Dir)
+	},
+}
+
+// storePruneCmd represents the store prune command
+var storePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unreferenced content from the store",
+	Long:  "Remove the files of the store which no project uses anymore: hardlinked files no project links to, and copied or cloned files of packages no installed project locks",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := pkg.LoadConfig()
+		store, err := pkg.OpenStore(config)
+		if err != nil {
+			exitWithError(err)
+		}
+		// Files are only removed while no install imports them
+		storeLock, err := store.Lock(cmd.Context(), config, true)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer storeLock.Release()
+		result, err := store.Prune()
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("🧹 Removed %d files (%.2f MB) and %d packages from the store\n", result.Files, float64(result.Bytes)/(1<<20), result.Indexes)
+	},
+}
+
+func init() {
+	StoreCmd.AddCommand(storePathCmd, storePruneCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emmadal/gopm/pkg"
+	"github.com/spf13/cobra"
+)
+
+// WhyCmd represents the why command
+var WhyCmd = &cobra.Command{
+	Use:     "why <package>",
+	Aliases: []string{"explain"},
+	Short:   "Explain why a package is installed",
+	Long:    "Print every path from package.json to a package of the resolved dependency graph, along with the range used at each edge",
+	Args:    cobra.MinimumNArgs(1),
+	Example: strings.Join([]string{
+		"$ gopm why lodash",
+		"$ gopm why lodash@^4 --json",
+		"$ gopm explain @babel/core",
+	}, "\n"),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if err := explainDependencies(args, asJSON); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// explainDependencies prints the dependency paths leading to packages.
+func explainDependencies(queries []string, asJSON bool) error {
+	lock, err := pkg.ReadLockfile()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return fmt.Errorf("%s not found. Run 'gopm install' first", pkg.LOCK_FILE)
+	}
+
+	results := []pkg.WhyResult{}
+	for _, query := range queries {
+		found, err := lock.Why(query)
+		if err != nil {
+			return err
+		}
+		results = append(results, found...)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", pkg.INDENT)
+		return encoder.Encode(results)
+	}
+	for _, result := range results {
+		fmt.Printf("%s\n", result.Package)
+		for _, path := range result.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+		if len(result.Paths) == 0 {
+			fmt.Println("  not required by any project")
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func init() {
+	WhyCmd.Flags().Bool("json", false, "Print the paths as JSON")
+}
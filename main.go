@@ -4,12 +4,16 @@ Copyright © 2025 Emmanuel Dalougou <emmanueldalougou@gmail.com>
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/emmadal/gopm/cmd"
+	"github.com/emmadal/gopm/pkg"
 	"github.com/spf13/cobra"
 )
 
@@ -19,16 +23,30 @@ var root = &cobra.Command{
 	Short: "Fast, efficient package manager for JavaScript and TypeScript",
 	Long:  "gopm is a package manager for JavaScript and TypeScript projects.\nIt is designed to be fast, efficient and easy to use and inspired by npm and yarn.",
 	Version: strings.Join([]string{
-		"v1.0.0",
+		"v" + pkg.VERSION,
 		fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
 		"https://github.com/emmadal/gopm",
 	}, "\n"),
 }
 
 func main() {
-	root.AddCommand(cmd.InitCmd, cmd.AddCmd, cmd.DevCmd, cmd.InstallCmd)
+	// The lock timeout applies to every command, it overrides the lock-timeout setting
+	root.PersistentFlags().String("lock-timeout", "", "How long to wait for another gopm process working on the project or the store, such as 30s or 2m, a bare number being seconds (default 5m, 0 fails immediately)")
+	root.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("lock-timeout") {
+			timeout, _ := cmd.Flags().GetString("lock-timeout")
+			pkg.SetConfig("lock-timeout", timeout)
+		}
+	}
+	root.AddCommand(cmd.InitCmd, cmd.AddCmd, cmd.AuditCmd, cmd.DevCmd, cmd.InstallCmd, cmd.DedupeCmd, cmd.LicensesCmd, cmd.PatchCmd, cmd.PatchCommitCmd, cmd.PruneCmd, cmd.RunCmd, cmd.SbomCmd, cmd.StoreCmd, cmd.WhyCmd)
+
+	// Ctrl-C cancels the context of the command, which stops and cleans up. Once
+	// cancelled, signals are no longer caught so that a second Ctrl-C exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	defer stop()
 
-	if err := root.Execute(); err != nil {
+	if err := root.ExecuteContext(ctx); err != nil {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	AUDIT_ENDPOINT = "-/npm/v1/security/advisories/bulk"
+)
+
+// Severities of advisories
+const (
+	SEVERITY_INFO     = "info"
+	SEVERITY_LOW      = "low"
+	SEVERITY_MODERATE = "moderate"
+	SEVERITY_HIGH     = "high"
+	SEVERITY_CRITICAL = "critical"
+)
+
+// SEVERITIES lists the severities from the least to the most severe.
+var SEVERITIES = []string{SEVERITY_INFO, SEVERITY_LOW, SEVERITY_MODERATE, SEVERITY_HIGH, SEVERITY_CRITICAL}
+
+// Advisory is a vulnerability as reported by the npm bulk advisory endpoint.
+type Advisory struct {
+	ID                 int      `json:"id"`
+	URL                string   `json:"url"`
+	Title              string   `json:"title"`
+	Severity           string   `json:"severity"`
+	VulnerableVersions string   `json:"vulnerable_versions"`
+	CWE                []string `json:"cwe,omitempty"`
+}
+
+// Advisories are the advisories of each package name, the response of the bulk advisory endpoint.
+type Advisories map[string][]Advisory
+
+// Vulnerability is a package of the lockfile affected by an advisory.
+type Vulnerability struct {
+	Package  string    `json:"package"`
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Advisory Advisory  `json:"advisory"`
+	Paths    []WhyPath `json:"paths"`
+}
+
+// AuditReport lists the vulnerabilities of a lockfile, the most severe first.
+type AuditReport struct {
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+	// Counts is the number of vulnerabilities by severity
+	Counts map[string]int `json:"counts"`
+	// Dependencies is the number of audited packages
+	Dependencies int `json:"dependencies"`
+}
+
+// SeverityRank returns the rank of a severity in SEVERITIES, -1 when it is unknown.
+func SeverityRank(severity string) int {
+	return slices.Index(SEVERITIES, strings.ToLower(severity))
+}
+
+// ParseSeverity validates an audit level.
+func ParseSeverity(level string) (string, error) {
+	if SeverityRank(level) < 0 {
+		return "", fmt.Errorf("unknown audit level %q, expected one of %s", level, strings.Join(SEVERITIES, ", "))
+	}
+	return strings.ToLower(level), nil
+}
+
+// Affecting returns the advisories affecting a version of a package.
+func (a Advisories) Affecting(name, version string) []Advisory {
+	affecting := []Advisory{}
+	for _, advisory := range a[name] {
+		if Satisfies(version, advisory.VulnerableVersions) {
+			affecting = append(affecting, advisory)
+		}
+	}
+	return affecting
+}
+
+// safe returns a copy of a package document without the versions affected by advisories.
+// Dist-tags pointing to an affected version are left out.
+func (a Advisories) safe(name string, body *BodyRegistery) *BodyRegistery {
+	safe := &BodyRegistery{Name: body.Name, DistTags: map[string]string{}, Versions: map[string]Manifest{}}
+	for version, manifest := range body.Versions {
+		if len(a.Affecting(name, version)) == 0 {
+			safe.Versions[version] = manifest
+		}
+	}
+	for tag, version := range body.DistTags {
+		if _, ok := safe.Versions[version]; ok {
+			safe.DistTags[tag] = version
+		}
+	}
+	return safe
+}
+
+// AuditRequest returns the versions of each registry package of the lockfile,
+// the body of a bulk advisory request.
+func (l *Lockfile) AuditRequest() map[string][]string {
+	request := map[string][]string{}
+	for key, p := range l.Packages {
+		if key == PackageKey(p.Name, p.Version) {
+			request[p.Name] = append(request[p.Name], p.Version)
+		}
+	}
+	for _, versions := range request {
+		SortVersions(versions)
+	}
+	return request
+}
+
+// FetchAdvisories sends a bulk advisory request to a registry.
+func FetchAdvisories(ctx context.Context, registry string, request map[string][]string) (Advisories, error) {
+	advisories := Advisories{}
+	if len(request) == 0 {
+		return advisories, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(registry, "/") + "/" + AUDIT_ENDPOINT
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize audit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, failure(FAILURE_NETWORK, "Failed to fetch advisories from %s: %w", registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure(FAILURE_NETWORK, "Failed to fetch advisories from %s: %s", registry, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&advisories); err != nil {
+		return nil, fmt.Errorf("Failed to decode advisories: %w", err)
+	}
+	return advisories, nil
+}
+
+// ReadAdvisories reads advisories saved in the format of the bulk advisory endpoint.
+func ReadAdvisories(path string) (Advisories, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	advisories := Advisories{}
+	if err := json.Unmarshal(data, &advisories); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return advisories, nil
+}
+
+// Audit evaluates advisories against the registry packages of the lockfile.
+func (l *Lockfile) Audit(advisories Advisories) *AuditReport {
+	report := &AuditReport{Vulnerabilities: []Vulnerability{}, Counts: map[string]int{}}
+	for _, severity := range SEVERITIES {
+		report.Counts[severity] = 0
+	}
+
+	request := l.AuditRequest()
+	for name, versions := range request {
+		report.Dependencies += len(versions)
+		for _, version := range versions {
+			for _, advisory := range advisories.Affecting(name, version) {
+				report.Vulnerabilities = append(report.Vulnerabilities, Vulnerability{
+					Package:  PackageKey(name, version),
+					Name:     name,
+					Version:  version,
+					Advisory: advisory,
+				})
+				report.Counts[strings.ToLower(advisory.Severity)]++
+			}
+		}
+	}
+
+	// Explain every vulnerable package once
+	vulnerable, paths := []string{}, map[string][]WhyPath{}
+	for _, v := range report.Vulnerabilities {
+		vulnerable = append(vulnerable, v.Package)
+	}
+	slices.Sort(vulnerable)
+	for _, result := range l.Explain(slices.Compact(vulnerable)) {
+		paths[result.Package] = result.Paths
+	}
+	for i := range report.Vulnerabilities {
+		report.Vulnerabilities[i].Paths = paths[report.Vulnerabilities[i].Package]
+	}
+	slices.SortFunc(report.Vulnerabilities, func(a, b Vulnerability) int {
+		if rank := SeverityRank(b.Advisory.Severity) - SeverityRank(a.Advisory.Severity); rank != 0 {
+			return rank
+		}
+		if key := strings.Compare(a.Package, b.Package); key != 0 {
+			return key
+		}
+		return a.Advisory.ID - b.Advisory.ID
+	})
+	return report
+}
+
+// AtLeast returns the number of vulnerabilities of a severity or higher.
+func (r *AuditReport) AtLeast(level string) int {
+	count := 0
+	for _, v := range r.Vulnerabilities {
+		if SeverityRank(v.Advisory.Severity) >= SeverityRank(level) {
+			count++
+		}
+	}
+	return count
+}
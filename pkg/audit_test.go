@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAudit ensures vulnerable packages are reported with their paths, most severe first
+func TestAudit(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"c": "^1.0.0"}},
+		"c": {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}})
+	advisories := Advisories{
+		"a": {{ID: 1, Title: "Fixed in a", Severity: SEVERITY_CRITICAL, VulnerableVersions: "<1.0.0"}},
+		"c": {
+			{ID: 2, Title: "Low in c", Severity: SEVERITY_LOW, VulnerableVersions: "<1.0.1"},
+			{ID: 3, Title: "High in c", Severity: SEVERITY_HIGH, VulnerableVersions: ">=1.0.0 <2.0.0"},
+		},
+	}
+
+	// A stand-in for the bulk advisory endpoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/"+AUDIT_ENDPOINT, r.URL.Path)
+		var request map[string][]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, map[string][]string{"a": {"1.0.0"}, "c": {"1.0.0"}}, request)
+		json.NewEncoder(w).Encode(advisories)
+	}))
+	defer server.Close()
+	fetched, err := FetchAdvisories(t.Context(), server.URL, lock.AuditRequest())
+	assert.NoError(t, err, "should fetch advisories")
+
+	report := lock.Audit(fetched)
+	assert.Equal(t, 2, report.Dependencies)
+	assert.Len(t, report.Vulnerabilities, 2, "advisories not matching the version are ignored")
+	assert.Equal(t, "High in c", report.Vulnerabilities[0].Advisory.Title, "most severe first")
+	assert.Equal(t, "c@1.0.0", report.Vulnerabilities[0].Package)
+	assert.Equal(t, ". > a@^1.0.0 (1.0.0) > c@^1.0.0 (1.0.0)", report.Vulnerabilities[0].Paths[0].String())
+	assert.Equal(t, 1, report.Counts[SEVERITY_HIGH])
+	assert.Equal(t, 1, report.AtLeast(SEVERITY_MODERATE))
+	assert.Equal(t, 0, report.AtLeast(SEVERITY_CRITICAL))
+
+	_, err = ParseSeverity("severe")
+	assert.Error(t, err, "unknown audit level")
+}
+
+// TestResolveAvoid ensures audit fix moves to safe versions within the ranges only
+func TestResolveAvoid(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": nil},
+		"b": {"1.0.0": nil},
+	}
+	manifest := &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"}}
+	lock := registry.resolve(t, manifest)
+
+	// Fixed versions are released after the lockfile was written
+	registry["a"]["1.0.1"] = nil
+	registry["b"]["2.0.0"] = nil
+	advisories := Advisories{
+		"a": {{ID: 1, Severity: SEVERITY_HIGH, VulnerableVersions: "<1.0.1"}},
+		"b": {{ID: 2, Severity: SEVERITY_HIGH, VulnerableVersions: "<2.0.0"}},
+	}
+
+	resolver := NewResolver(lock)
+	resolver.Fetch = registry.fetch
+	relocked, err := resolver.Resolve(t.Context(), manifest, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, relocked.Packages, "a@1.0.0", "locked versions are kept without advisories")
+
+	resolver = NewResolver(lock)
+	resolver.Fetch = registry.fetch
+	resolver.Avoid = advisories
+	fixed, err := resolver.Resolve(t.Context(), manifest, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, fixed.Packages, "a@1.0.1", "should upgrade to the safe version")
+	assert.Contains(t, fixed.Packages, "b@1.0.0", "should not leave the range of package.json")
+	assert.Len(t, fixed.Audit(advisories).Vulnerabilities, 1)
+}
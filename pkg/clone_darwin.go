@@ -0,0 +1,8 @@
+package pkg
+
+import "golang.org/x/sys/unix"
+
+// cloneFile creates a copy-on-write clone of a file (APFS).
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
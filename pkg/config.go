@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	NPMRC             = ".npmrc"
+	SAVE_PREFIX_CARET = "^"
+	SAVE_PREFIX_TILDE = "~"
+)
+
+// Config is a representation of the settings read from .npmrc files.
+type Config map[string]string
+
+// overrides holds the settings given on the command line.
+var overrides = Config{}
+
+// SetConfig overrides a setting for the configurations loaded afterwards, such as
+// from a command line flag. Unlike an npm_config_* variable, it is not passed on to scripts.
+func SetConfig(key, value string) {
+	overrides[key] = value
+}
+
+// LoadConfig reads the user (~/.npmrc) then the project (.npmrc) configuration.
+// Project settings override user ones, npm_config_* environment variables override both
+// and settings given with SetConfig override everything.
+func LoadConfig() Config {
+	config := Config{}
+	if home, err := os.UserHomeDir(); err == nil {
+		config.readFile(filepath.Join(home, NPMRC))
+	}
+	config.readFile(filepath.Join(GetCwd(), NPMRC))
+
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(strings.ToLower(key), "npm_config_"); ok {
+			config[strings.ReplaceAll(name, "_", "-")] = value
+		}
+	}
+	maps.Copy(config, overrides)
+	return config
+}
+
+// readFile reads key=value lines of an .npmrc file, ignoring comments.
+func (c Config) readFile(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		c[strings.TrimSpace(key)] = value
+	}
+}
+
+// Get returns the value of a setting or the fallback when it is not set.
+func (c Config) Get(key, fallback string) string {
+	if value, ok := c[key]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Bool returns whether a setting is set to true.
+func (c Config) Bool(key string) bool {
+	return strings.EqualFold(c[key], "true")
+}
+
+// List returns the values of a setting separated by spaces or commas.
+func (c Config) List(key string) []string {
+	return strings.FieldsFunc(c[key], func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// SavePrefix returns the range operator prefixed to versions saved in
+// package.json, empty when save-exact is set. It defaults to ^ like npm.
+func (c Config) SavePrefix() (string, error) {
+	if c.Bool("save-exact") {
+		return "", nil
+	}
+	prefix, ok := c["save-prefix"]
+	if !ok {
+		return SAVE_PREFIX_CARET, nil
+	}
+	switch prefix {
+	case "", SAVE_PREFIX_CARET, SAVE_PREFIX_TILDE:
+		return prefix, nil
+	}
+	return "", fmt.Errorf("unknown save-prefix %q, expected ^ or ~", prefix)
+}
+
+// Registry returns the URL of the registry, ending with a slash.
+func (c Config) Registry() string {
+	registry := c.Get("registry", NPM_REGISTRY)
+	if !strings.HasSuffix(registry, "/") {
+		registry += "/"
+	}
+	return registry
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"maps"
+	"slices"
+)
+
+// dedupeEdge is a dependency edge that may be pointed to another version of its package.
+type dedupeEdge struct {
+	target map[string]LockDependency
+	name   string
+	rng    string
+}
+
+// Dedupe returns a copy of the lockfile resolving every package to the fewest
+// distinct versions already in the graph that satisfy all the ranges requiring
+// it, without the packages no longer required. Overridden packages are left
+// as they are. The lockfile itself is left intact.
+func (l *Lockfile) Dedupe() *Lockfile {
+	out := l.clone()
+	overridden := l.overriddenNames()
+
+	// Group the edges pointing to registry packages by package name
+	edges := map[string][]dedupeEdge{}
+	versions := map[string][]string{}
+	for key, p := range out.Packages {
+		if key == PackageKey(p.Name, p.Version) {
+			versions[p.Name] = append(versions[p.Name], p.Version)
+		}
+	}
+	targets := []map[string]LockDependency{}
+	for _, importer := range out.Importers {
+		targets = append(targets, importer.Dependencies, importer.DevDependencies, importer.OptionalDependencies, importer.PeerDependencies)
+	}
+	for _, p := range out.Packages {
+		targets = append(targets, p.Dependencies, p.OptionalDependencies)
+	}
+	for _, target := range targets {
+		for name, dep := range target {
+			p, ok := out.Packages[dep.Package]
+			if !ok || dep.Package != PackageKey(p.Name, p.Version) || len(versions[p.Name]) < 2 || overridden[p.Name] {
+				continue
+			}
+			// Dist-tags and other specifiers which are not ranges stay on their version
+			rng := p.Version
+			if spec, err := ParseDependency(name, dep.Specifier); err == nil && !spec.IsTag() {
+				rng = spec.Range
+			}
+			edges[p.Name] = append(edges[p.Name], dedupeEdge{target, name, rng})
+		}
+	}
+
+	for name, pending := range edges {
+		candidates := slices.Clone(versions[name])
+		SortVersions(candidates)
+		slices.Reverse(candidates)
+
+		// Greedily pick the version satisfying most of the remaining ranges, the highest on ties
+		for len(pending) > 0 {
+			best, covered := "", -1
+			for _, version := range candidates {
+				count := 0
+				for _, edge := range pending {
+					if Satisfies(version, edge.rng) {
+						count++
+					}
+				}
+				if count > covered {
+					best, covered = version, count
+				}
+			}
+			if covered == 0 {
+				break
+			}
+			key := PackageKey(name, best)
+			pending = slices.DeleteFunc(pending, func(edge dedupeEdge) bool {
+				if !Satisfies(best, edge.rng) {
+					return false
+				}
+				dep := edge.target[edge.name]
+				dep.Package = key
+				edge.target[edge.name] = dep
+				return true
+			})
+		}
+	}
+
+	deduped := out.Omit(nil)
+	deduped.markFlags()
+	return deduped
+}
+
+// clone returns a copy of the lockfile whose edges can be modified.
+func (l *Lockfile) clone() *Lockfile {
+	out := &Lockfile{LockfileVersion: l.LockfileVersion, Importers: map[string]*LockImporter{}, Packages: map[string]*LockPackage{}, Overrides: l.Overrides}
+	for dir, importer := range l.Importers {
+		out.Importers[dir] = &LockImporter{
+			Dependencies:         maps.Clone(importer.Dependencies),
+			DevDependencies:      maps.Clone(importer.DevDependencies),
+			OptionalDependencies: maps.Clone(importer.OptionalDependencies),
+			PeerDependencies:     maps.Clone(importer.PeerDependencies),
+		}
+	}
+	for key, p := range l.Packages {
+		copied := *p
+		copied.Dependencies = maps.Clone(p.Dependencies)
+		copied.OptionalDependencies = maps.Clone(p.OptionalDependencies)
+		out.Packages[key] = &copied
+	}
+	return out
+}
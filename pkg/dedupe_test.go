@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDedupe ensures overlapping ranges collapse onto a single version
+func TestDedupe(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"c": "^1.0.0"}},
+		"c": {"1.0.0": {"d": "^1.0.0"}},
+		"d": {"1.0.0": nil},
+	}
+	locked := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}})
+
+	// c@1.1.0 is published and required by b while a stays on its locked version
+	registry["b"] = map[string]map[string]string{"1.0.0": {"c": "^1.1.0"}}
+	registry["c"]["1.1.0"] = nil
+	registry["e"] = map[string]map[string]string{"1.0.0": {"c": "~1.0.0"}}
+	resolver := NewResolver(locked)
+	resolver.Fetch = registry.fetch
+	lock, err := resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"}}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, lock.Packages, "c@1.0.0")
+	assert.Contains(t, lock.Packages, "c@1.1.0")
+
+	deduped := lock.Dedupe()
+	assert.Equal(t, "c@1.1.0", deduped.Packages["a@1.0.0"].Dependencies["c"].Package)
+	assert.NotContains(t, deduped.Packages, "c@1.0.0", "the duplicate should be removed")
+	assert.NotContains(t, deduped.Packages, "d@1.0.0", "dependencies of the duplicate should be removed")
+	assert.Contains(t, lock.Packages, "c@1.0.0", "the lockfile should be left intact")
+
+	// Versions required by incompatible ranges are kept
+	lock, err = resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0", "e": "^1.0.0"}}, nil)
+	assert.NoError(t, err)
+	deduped = lock.Dedupe()
+	assert.Contains(t, deduped.Packages, "c@1.0.0")
+	assert.Contains(t, deduped.Packages, "c@1.1.0")
+}
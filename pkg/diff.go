@@ -0,0 +1,428 @@
+package pkg
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DIFF_CONTEXT = 3
+	DEV_NULL     = "/dev/null"
+	NO_NEWLINE   = "\\ No newline at end of file"
+)
+
+// diffOp is a line of an edit script: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffHunk is a hunk of a unified diff, line numbers start at 1.
+type diffHunk struct {
+	oldStart, oldLen int
+	newStart, newLen int
+	ops              []diffOp
+}
+
+// FilePatch is the unified diff of a single file, paths are relative to the package.
+// OldPath is empty for created files and NewPath is empty for deleted files.
+// Modes are 0644 or 0755 like in the store, 0 when the patch does not record them.
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	OldMode uint32
+	NewMode uint32
+	hunks   []diffHunk
+}
+
+// splitLines splits text into lines keeping their line feed, the last line may have none.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script from a to b. Lines common to the
+// start and the end of both texts are kept without searching them.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, editScript(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// editScript computes the shortest edit script from a to b with the Myers algorithm.
+// Only the diagonals -d to d reached at each step d are kept to walk back the script,
+// so memory grows with the number of edits rather than the size of the texts.
+func editScript(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	ops := make([]diffOp, 0, n+m)
+	if n == 0 || m == 0 {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	limit := n + m
+	offset := limit
+	v := make([]int, 2*limit+2)
+	// trace[d][k+d] is the furthest x reached on diagonal k after step d
+	trace := [][]int{}
+
+search:
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+		trace = append(trace, slices.Clone(v[offset-d:offset+d+1]))
+	}
+
+	// Walk the trace backwards to recover the edit script
+	x, y := n, m
+	for d := len(trace); d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// groupHunks groups an edit script into hunks with DIFF_CONTEXT lines of context.
+func groupHunks(ops []diffOp) []diffHunk {
+	// Line numbers, starting at 0, of each operation in the old and new texts
+	oldLines, newLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if op.kind != '+' {
+			oldLines[i+1]++
+		}
+		if op.kind != '-' {
+			newLines[i+1]++
+		}
+	}
+
+	hunks := []diffHunk{}
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		start, last := max(0, i-DIFF_CONTEXT), i
+		for j := i; j < len(ops) && j-last <= 2*DIFF_CONTEXT; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+		end := min(len(ops), last+1+DIFF_CONTEXT)
+		hunk := diffHunk{
+			oldStart: oldLines[start] + 1, oldLen: oldLines[end] - oldLines[start],
+			newStart: newLines[start] + 1, newLen: newLines[end] - newLines[start],
+			ops: ops[start:end],
+		}
+		// An empty range starts at the line before it
+		if hunk.oldLen == 0 {
+			hunk.oldStart--
+		}
+		if hunk.newLen == 0 {
+			hunk.newStart--
+		}
+		hunks = append(hunks, hunk)
+		i = end - 1
+	}
+	return hunks
+}
+
+// DiffFile returns the patch turning oldText into newText, nil when they are equal.
+func DiffFile(oldPath, newPath, oldText, newText string) *FilePatch {
+	if oldText == newText && oldPath != "" && newPath != "" {
+		return nil
+	}
+	return &FilePatch{OldPath: oldPath, NewPath: newPath, hunks: groupHunks(diffLines(splitLines(oldText), splitLines(newText)))}
+}
+
+// String formats the patch as a git style unified diff.
+func (f *FilePatch) String() string {
+	var b strings.Builder
+	name := f.NewPath
+	if name == "" {
+		name = f.OldPath
+	}
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", name, name)
+	switch {
+	case f.OldPath == "":
+		fmt.Fprintf(&b, "new file mode 100%o\n", cmp.Or(f.NewMode, 0644))
+	case f.NewPath == "":
+		fmt.Fprintf(&b, "deleted file mode 100%o\n", cmp.Or(f.OldMode, 0644))
+	case f.OldMode != 0 && f.NewMode != 0 && f.OldMode != f.NewMode:
+		fmt.Fprintf(&b, "old mode 100%o\nnew mode 100%o\n", f.OldMode, f.NewMode)
+	}
+	oldPath, newPath := DEV_NULL, DEV_NULL
+	if f.OldPath != "" {
+		oldPath = "a/" + f.OldPath
+	}
+	if f.NewPath != "" {
+		newPath = "b/" + f.NewPath
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldPath, newPath)
+	for _, hunk := range f.hunks {
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", hunk.oldStart, hunk.oldLen, hunk.newStart, hunk.newLen)
+		for _, op := range hunk.ops {
+			b.WriteByte(op.kind)
+			b.WriteString(strings.TrimSuffix(op.line, "\n"))
+			b.WriteByte('\n')
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString(NO_NEWLINE + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// ParsePatch parses a unified diff made of one or more file patches.
+func ParsePatch(text string) ([]*FilePatch, error) {
+	patches := []*FilePatch{}
+	lines := strings.Split(text, "\n")
+	var current *FilePatch
+	// A git header starts the file patch, the --- line following it only sets its old path
+	gitHeader := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			oldPath, newPath, _ := strings.Cut(strings.TrimPrefix(line, "diff --git a/"), " b/")
+			current = &FilePatch{OldPath: oldPath, NewPath: newPath}
+			patches = append(patches, current)
+			gitHeader = true
+		case strings.HasPrefix(line, "new file mode "), strings.HasPrefix(line, "new mode "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: mode without file header", i+1)
+			}
+			current.NewMode = parseMode(line)
+			if strings.HasPrefix(line, "new file") {
+				current.OldPath = ""
+			}
+		case strings.HasPrefix(line, "deleted file mode "), strings.HasPrefix(line, "old mode "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: mode without file header", i+1)
+			}
+			current.OldMode = parseMode(line)
+			if strings.HasPrefix(line, "deleted file") {
+				current.NewPath = ""
+			}
+		case strings.HasPrefix(line, "--- "):
+			if !gitHeader {
+				current = &FilePatch{}
+				patches = append(patches, current)
+			}
+			current.OldPath = patchPath(line[4:], "a/")
+			gitHeader = false
+		case strings.HasPrefix(line, "+++ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: +++ without ---", i+1)
+			}
+			current.NewPath = patchPath(line[4:], "b/")
+		case strings.HasPrefix(line, "@@ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without file header", i+1)
+			}
+			hunk, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			current.hunks = append(current.hunks, hunk)
+			i = next - 1
+		}
+	}
+	return patches, nil
+}
+
+// parseMode returns the permissions of a git mode line, the store only knows 0644 and 0755.
+func parseMode(line string) uint32 {
+	mode, err := strconv.ParseUint(line[strings.LastIndexByte(line, ' ')+1:], 8, 32)
+	if err != nil || mode&0111 == 0 {
+		return 0644
+	}
+	return 0755
+}
+
+// patchPath returns the path of a --- or +++ header without its a/ or b/ prefix.
+func patchPath(header, prefix string) string {
+	header, _, _ = strings.Cut(header, "\t")
+	if header == DEV_NULL {
+		return ""
+	}
+	return strings.TrimPrefix(header, prefix)
+}
+
+// parseHunk parses the hunk starting at lines[start] and returns the index of the line after it.
+func parseHunk(lines []string, start int) (diffHunk, int, error) {
+	var hunk diffHunk
+	header := strings.TrimPrefix(lines[start], "@@ ")
+	header, _, _ = strings.Cut(header, " @@")
+	oldRange, newRange, ok := strings.Cut(header, " ")
+	if !ok || !strings.HasPrefix(oldRange, "-") || !strings.HasPrefix(newRange, "+") {
+		return hunk, 0, fmt.Errorf("line %d: invalid hunk header %q", start+1, lines[start])
+	}
+	var err error
+	if hunk.oldStart, hunk.oldLen, err = parseRange(oldRange[1:]); err != nil {
+		return hunk, 0, fmt.Errorf("line %d: %w", start+1, err)
+	}
+	if hunk.newStart, hunk.newLen, err = parseRange(newRange[1:]); err != nil {
+		return hunk, 0, fmt.Errorf("line %d: %w", start+1, err)
+	}
+
+	oldLeft, newLeft := hunk.oldLen, hunk.newLen
+	i := start + 1
+	for ; i < len(lines) && (oldLeft > 0 || newLeft > 0 || strings.HasPrefix(lines[i], "\\")); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "\\") {
+			// The previous line has no line feed
+			if len(hunk.ops) > 0 {
+				last := &hunk.ops[len(hunk.ops)-1]
+				last.line = strings.TrimSuffix(last.line, "\n")
+			}
+			continue
+		}
+		kind, content := byte(' '), ""
+		if line != "" {
+			kind, content = line[0], line[1:]
+		}
+		switch kind {
+		case ' ':
+			oldLeft, newLeft = oldLeft-1, newLeft-1
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		default:
+			return hunk, 0, fmt.Errorf("line %d: unexpected %q in hunk", i+1, line)
+		}
+		hunk.ops = append(hunk.ops, diffOp{kind, content + "\n"})
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return hunk, 0, fmt.Errorf("line %d: truncated hunk", start+1)
+	}
+	return hunk, i, nil
+}
+
+// parseRange parses start,length or start (length 1) of a hunk header.
+func parseRange(text string) (int, int, error) {
+	startText, lengthText, ok := strings.Cut(text, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hunk range %q", text)
+	}
+	length := 1
+	if ok {
+		if length, err = strconv.Atoi(lengthText); err != nil {
+			return 0, 0, fmt.Errorf("invalid hunk range %q", text)
+		}
+	}
+	return start, length, nil
+}
+
+// Apply applies the hunks of the patch to text. Hunks are searched around their
+// position to tolerate shifted lines, but their context must match exactly.
+func (f *FilePatch) Apply(text string) (string, error) {
+	lines := []string{}
+	if text != "" {
+		lines = splitLines(text)
+	}
+	result := []string{}
+	position := 0
+	for n, hunk := range f.hunks {
+		old, replacement := []string{}, []string{}
+		for _, op := range hunk.ops {
+			if op.kind != '+' {
+				old = append(old, op.line)
+			}
+			if op.kind != '-' {
+				replacement = append(replacement, op.line)
+			}
+		}
+		expected := max(hunk.oldStart-1, 0)
+		if hunk.oldLen == 0 {
+			expected = hunk.oldStart
+		}
+		at := findLines(lines, old, position, expected)
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d (line %d) does not apply", n+1, hunk.oldStart)
+		}
+		result = append(append(result, lines[position:at]...), replacement...)
+		position = at + len(old)
+	}
+	return strings.Join(append(result, lines[position:]...), ""), nil
+}
+
+// findLines finds needle in lines at or after from, searching outwards from expected.
+func findLines(lines, needle []string, from, expected int) int {
+	matches := func(at int) bool {
+		return at >= from && at+len(needle) <= len(lines) && slices.Equal(lines[at:at+len(needle)], needle)
+	}
+	for delta := 0; delta <= len(lines); delta++ {
+		if matches(expected + delta) {
+			return expected + delta
+		}
+		if delta > 0 && matches(expected-delta) {
+			return expected - delta
+		}
+	}
+	return -1
+}
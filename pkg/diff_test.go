@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffFile ensures generated patches round trip and apply to shifted files
+func TestDiffFile(t *testing.T) {
+	lines := []string{}
+	for i := range 20 {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+	oldText := strings.Join(lines, "\n") + "\n"
+	edited := append([]string{}, lines...)
+	edited[2], edited[15] = "changed", "also changed"
+	newText := strings.Join(edited, "\n")
+
+	patch := DiffFile("index.js", "index.js", oldText, newText)
+	assert.NotNil(t, patch)
+	assert.Nil(t, DiffFile("index.js", "index.js", oldText, oldText), "equal files have no patch")
+	assert.Contains(t, patch.String(), NO_NEWLINE, "should record the missing line feed")
+
+	files, err := ParsePatch(patch.String())
+	assert.NoError(t, err, "should parse the generated patch")
+	assert.Len(t, files, 1)
+	assert.Equal(t, "index.js", files[0].OldPath)
+	assert.Equal(t, 2, len(files[0].hunks), "distant changes should make two hunks")
+
+	applied, err := files[0].Apply(oldText)
+	assert.NoError(t, err)
+	assert.Equal(t, newText, applied, "applying the patch should give the edited text")
+
+	// Lines inserted above the hunks shift them
+	shifted, err := files[0].Apply("header\nheader\n" + oldText)
+	assert.NoError(t, err, "should apply at an offset")
+	assert.Equal(t, "header\nheader\n"+newText, shifted)
+
+	_, err = files[0].Apply(strings.Replace(oldText, "xxx\n", "yyy\n", 1))
+	assert.Error(t, err, "should fail when the context changed")
+
+	created, err := ParsePatch(DiffFile("", "new.js", "", "a\nb\n").String())
+	assert.NoError(t, err)
+	assert.Equal(t, "", created[0].OldPath, "created files come from /dev/null")
+	text, err := created[0].Apply("")
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\n", text)
+
+	// A large file added to an empty one is a single hunk of added lines
+	large := strings.Repeat("line\n", 100000)
+	appended := DiffFile("big.js", "big.js", "", large)
+	assert.Len(t, appended.hunks, 1)
+	assert.Len(t, appended.hunks[0].ops, 100000)
+}
+
+// TestApplyPatch ensures patches edit, create and delete files of an installed package
+func TestApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.js"), []byte("module.exports = 1\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "old.js"), []byte("old\n"), 0644))
+
+	patch := DiffFile("index.js", "index.js", "module.exports = 1\n", "module.exports = 2\n").String() +
+		DiffFile("", "lib/new.js", "", "new\n").String() +
+		DiffFile("old.js", "", "old\n", "").String()
+	assert.NoError(t, ApplyPatch(dir, patch), "should apply the patch")
+
+	data, _ := os.ReadFile(filepath.Join(dir, "index.js"))
+	assert.Equal(t, "module.exports = 2\n", string(data))
+	data, _ = os.ReadFile(filepath.Join(dir, "lib", "new.js"))
+	assert.Equal(t, "new\n", string(data))
+	assert.NoFileExists(t, filepath.Join(dir, "old.js"))
+
+	assert.Error(t, ApplyPatch(dir, patch), "should fail once the files changed")
+
+	// Modes are recorded for created files and executable bit changes
+	bin := DiffFile("", "bin/cli.js", "", "#!/usr/bin/env node\n")
+	bin.NewMode = 0755
+	chmod := &FilePatch{OldPath: "index.js", NewPath: "index.js", OldMode: 0644, NewMode: 0755}
+	assert.Contains(t, chmod.String(), "old mode 100644\nnew mode 100755\n")
+	files, err := ParsePatch(bin.String() + chmod.String())
+	assert.NoError(t, err)
+	assert.Len(t, files, 2, "a mode change without hunks is a file patch")
+	assert.NoError(t, ApplyPatch(dir, bin.String()+chmod.String()))
+	if runtime.GOOS != "windows" {
+		for _, name := range []string{"bin/cli.js", "index.js"} {
+			info, err := os.Stat(filepath.Join(dir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm(), name+" should be executable")
+		}
+	}
+	assert.Error(t, ApplyPatch(dir, DiffFile("", "../escape.js", "", "x\n").String()), "should reject paths outside the package")
+}
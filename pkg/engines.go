@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// VERSION is the version of gopm, checked against engines.gopm and packageManager
+	VERSION         = "1.0.0"
+	PACKAGE_MANAGER = "gopm"
+	ENGINE_NODE     = "node"
+	ENGINE_GOPM     = "gopm"
+)
+
+// packageManagerField matches the corepack packageManager field, <name>@<version>
+// optionally followed by +<algorithm>.<hash>.
+var packageManagerField = regexp.MustCompile(`^((?:@[^/@\s]+/)?[^@\s]+)@([^+\s]+)(?:\+[a-z0-9]+\.[0-9a-fA-F]+)?$`)
+
+// Engines are the version ranges of the runtimes a package supports, by runtime name.
+type Engines map[string]string
+
+// UnmarshalJSON ignores the legacy array form of engines, which cannot be checked.
+func (e *Engines) UnmarshalJSON(data []byte) error {
+	var engines map[string]string
+	if json.Unmarshal(data, &engines) != nil {
+		*e = nil
+		return nil
+	}
+	*e = engines
+	return nil
+}
+
+// EngineMismatch is an engine whose version does not satisfy the range required by a package.
+type EngineMismatch struct {
+	Package string
+	Engine  string
+	Range   string
+	Version string
+}
+
+// String formats the mismatch as <package> requires <engine> <range>, found <version>.
+func (m EngineMismatch) String() string {
+	return fmt.Sprintf("%s requires %s %s, found %s", m.Package, m.Engine, m.Range, m.Version)
+}
+
+// EngineVersions returns the versions engines are checked against: the version
+// of gopm, and the version of node when it is found on the PATH.
+func EngineVersions(ctx context.Context) map[string]string {
+	versions := map[string]string{ENGINE_GOPM: VERSION}
+	if out, err := exec.CommandContext(ctx, "node", "--version").Output(); err == nil {
+		versions[ENGINE_NODE] = strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+	}
+	return versions
+}
+
+// Check returns the engines of a package whose version does not satisfy their range.
+// Unknown engines and invalid ranges are skipped.
+func (e Engines) Check(name string, versions map[string]string) []EngineMismatch {
+	mismatches := []EngineMismatch{}
+	for _, engine := range slices.Sorted(maps.Keys(e)) {
+		version, ok := versions[engine]
+		if rng := e[engine]; ok && IsValidRange(rng) && !Satisfies(version, rng) {
+			mismatches = append(mismatches, EngineMismatch{name, engine, rng, version})
+		}
+	}
+	return mismatches
+}
+
+// CheckEngines returns the engine mismatches of the packages of the lockfile, sorted by package.
+func (l *Lockfile) CheckEngines(versions map[string]string) []EngineMismatch {
+	mismatches := []EngineMismatch{}
+	for _, key := range slices.Sorted(maps.Keys(l.Packages)) {
+		mismatches = append(mismatches, l.Packages[key].Engines.Check(key, versions)...)
+	}
+	return mismatches
+}
+
+// ParsePackageManager parses the packageManager field of package.json, such as
+// gopm@1.0.0 or pnpm@9.1.0+sha512.<hash>, into a name and an exact version.
+func ParsePackageManager(field string) (string, string, error) {
+	m := packageManagerField.FindStringSubmatch(strings.TrimSpace(field))
+	if m == nil {
+		return "", "", fmt.Errorf("invalid packageManager %q, expected <name>@<version>", field)
+	}
+	if _, err := ParseVersion(m[2]); err != nil {
+		return "", "", fmt.Errorf("invalid packageManager %q, the version must be exact: %w", field, err)
+	}
+	return m[1], m[2], nil
+}
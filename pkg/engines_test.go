@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEngines ensures packages are checked against the node and gopm versions of their engines
+func TestEngines(t *testing.T) {
+	var manifest Manifest
+	assert.NoError(t, json.Unmarshal([]byte(`{"name":"old","engines":["node >= 0.4"]}`), &manifest), "the legacy array form is ignored")
+	assert.Nil(t, manifest.Engines)
+
+	versions := map[string]string{ENGINE_NODE: "18.19.0", ENGINE_GOPM: "1.0.0"}
+	lock := NewLockfile()
+	lock.Packages["a@1.0.0"] = &LockPackage{Name: "a", Version: "1.0.0", Engines: Engines{ENGINE_NODE: ">=20"}}
+	lock.Packages["b@1.0.0"] = &LockPackage{Name: "b", Version: "1.0.0", Engines: Engines{ENGINE_NODE: "^18 || ^20", "npm": ">=99"}}
+	lock.Packages["c@1.0.0"] = &LockPackage{Name: "c", Version: "1.0.0", Engines: Engines{ENGINE_GOPM: ">=2", ENGINE_NODE: "not a range"}}
+	assert.Equal(t, []EngineMismatch{
+		{"a@1.0.0", ENGINE_NODE, ">=20", "18.19.0"},
+		{"c@1.0.0", ENGINE_GOPM, ">=2", "1.0.0"},
+	}, lock.CheckEngines(versions), "unknown engines and invalid ranges are skipped")
+	assert.Empty(t, lock.CheckEngines(map[string]string{ENGINE_GOPM: "2.0.0"}), "node is not checked when it is not found")
+	assert.Equal(t, "a@1.0.0 requires node >=20, found 18.19.0", lock.CheckEngines(versions)[0].String())
+
+	for field, expected := range map[string][2]string{
+		"gopm@1.0.0":              {"gopm", "1.0.0"},
+		"pnpm@9.1.0+sha512.0abc1": {"pnpm", "9.1.0"},
+		"@acme/pm@2.0.0-rc.1":     {"@acme/pm", "2.0.0-rc.1"},
+	} {
+		name, version, err := ParsePackageManager(field)
+		assert.NoError(t, err, field)
+		assert.Equal(t, expected, [2]string{name, version}, field)
+	}
+	for _, field := range []string{"gopm", "gopm@^1.0.0", "gopm@latest"} {
+		_, _, err := ParsePackageManager(field)
+		assert.Error(t, err, field)
+	}
+}
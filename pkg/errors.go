@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	FAILURE_NOT_FOUND  = "not found"
+	FAILURE_NETWORK    = "network"
+	FAILURE_INTEGRITY  = "integrity"
+	FAILURE_EXTRACTION = "extraction"
+	FAILURE_SCRIPT     = "script"
+)
+
+const (
+	EXIT_FAILURE     = 1
+	EXIT_NOT_FOUND   = 3
+	EXIT_NETWORK     = 4
+	EXIT_INTEGRITY   = 5
+	EXIT_EXTRACTION  = 6
+	EXIT_SCRIPT      = 7
+	EXIT_INTERRUPTED = 130
+)
+
+// exitCodes are the exit codes of gopm by kind of failure.
+var exitCodes = map[string]int{
+	FAILURE_NOT_FOUND:  EXIT_NOT_FOUND,
+	FAILURE_NETWORK:    EXIT_NETWORK,
+	FAILURE_INTEGRITY:  EXIT_INTEGRITY,
+	FAILURE_EXTRACTION: EXIT_EXTRACTION,
+	FAILURE_SCRIPT:     EXIT_SCRIPT,
+}
+
+// Failure is an error classified by kind: a package or version not found, a network
+// error, an integrity mismatch, a tarball which cannot be extracted or a failed script.
+// Unclassified errors have no kind.
+type Failure struct {
+	Kind string
+	Err  error
+}
+
+// failure formats a failure of a kind like fmt.Errorf.
+func failure(kind, format string, args ...any) error {
+	return &Failure{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// Error returns the message of the failure.
+func (f *Failure) Error() string {
+	return f.Err.Error()
+}
+
+// Unwrap returns the error the failure classifies.
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Failures splits an error joining several failures, as built by errors.Join, into
+// each of them. Wrapped failures keep the message of the error wrapping them.
+func Failures(err error) []*Failure {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		failures := []*Failure{}
+		for _, e := range joined.Unwrap() {
+			failures = append(failures, Failures(e)...)
+		}
+		return failures
+	}
+	var f *Failure
+	if errors.As(err, &f) {
+		return []*Failure{{Kind: f.Kind, Err: err}}
+	}
+	return []*Failure{{Err: err}}
+}
+
+// ExitCode returns the exit code of a failed command: 130 when it was interrupted,
+// the code of its kind of failure, the lowest one when it failed for several kinds,
+// and 1 when the failure is not classified.
+func ExitCode(err error) int {
+	if errors.Is(err, ErrInterrupted) || errors.Is(err, context.Canceled) {
+		return EXIT_INTERRUPTED
+	}
+	code := EXIT_FAILURE
+	for _, f := range Failures(err) {
+		if c, ok := exitCodes[f.Kind]; ok && (code == EXIT_FAILURE || c < code) {
+			code = c
+		}
+	}
+	return code
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFailures ensures failures are classified, collected for every package and mapped to exit codes
+func TestFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.tgz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("not a tarball"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	kind := func(err error) string {
+		var f *Failure
+		if !errors.As(err, &f) {
+			return ""
+		}
+		return f.Kind
+	}
+	_, err := DownloadTarball(t.Context(), "missing", server.URL+"/missing.tgz", "", filepath.Join(dir, "missing.tgz"))
+	assert.Equal(t, FAILURE_NOT_FOUND, kind(err))
+	_, err = DownloadTarball(t.Context(), "tampered", server.URL+"/tampered.tgz", "sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=", filepath.Join(dir, "tampered.tgz"))
+	assert.Equal(t, FAILURE_INTEGRITY, kind(err))
+	_, err = DownloadTarball(t.Context(), "down", "http://127.0.0.1:1/down.tgz", "", filepath.Join(dir, "down.tgz"))
+	assert.Equal(t, FAILURE_NETWORK, kind(err))
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store")})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.tgz"), []byte("not a tarball"), 0644))
+	_, err = store.ImportTarball(t.Context(), filepath.Join(dir, "garbage.tgz"), "")
+	assert.Equal(t, FAILURE_EXTRACTION, kind(err))
+
+	// Every dependency failing to resolve is reported, once
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"b": "^2.0.0", "gone": "^1.0.0"}},
+		"b": {"1.0.0": nil},
+		"c": {"1.0.0": {"b": "^2.0.0"}},
+	}
+	resolver := NewResolver(nil)
+	resolver.Fetch = registry.fetch
+	_, err = resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0", "c": "^1.0.0"}}, nil)
+	failures := Failures(err)
+	assert.Len(t, failures, 2)
+	assert.Equal(t, FAILURE_NOT_FOUND, failures[0].Kind)
+	assert.Equal(t, "No version of b satisfies ^2.0.0", failures[0].Error())
+	assert.Equal(t, "", failures[1].Kind, "unclassified failures are kept")
+	assert.Equal(t, EXIT_NOT_FOUND, ExitCode(err))
+
+	assert.Equal(t, EXIT_FAILURE, ExitCode(errors.New("boom")))
+	assert.Equal(t, EXIT_SCRIPT, ExitCode(fmt.Errorf("a@1.0.0: %w", failure(FAILURE_SCRIPT, "script install of a failed"))))
+	assert.Equal(t, EXIT_NETWORK, ExitCode(errors.Join(failure(FAILURE_INTEGRITY, "tampered"), errors.New("boom"), failure(FAILURE_NETWORK, "down"))), "the lowest exit code wins")
+	assert.Equal(t, EXIT_INTERRUPTED, ExitCode(ErrInterrupted))
+	assert.Equal(t, EXIT_INTERRUPTED, ExitCode(fmt.Errorf("Failed to download a: %w", context.Canceled)))
+}
@@ -0,0 +1,401 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var commitRegexp = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// FetchSpec fetches a dependency from outside the registry into the store and
+// returns it as a lockfile package, pinned to an exact commit or content hash,
+// along with its manifest.
+func (s *Store) FetchSpec(ctx context.Context, spec *Spec) (*LockPackage, *Manifest, error) {
+	switch spec.Type {
+	case SPEC_GIT:
+		return s.fetchGit(ctx, spec)
+	case SPEC_FILE:
+		return s.fetchFile(spec)
+	case SPEC_LINK:
+		return fetchLink(spec)
+	case SPEC_TARBALL:
+		return s.fetchTarball(ctx, spec)
+	}
+	return nil, nil, fmt.Errorf("%s is not fetched from outside the registry", spec.Raw)
+}
+
+// fetchGit resolves a git dependency to a commit and imports its checkout into the store.
+func (s *Store) fetchGit(ctx context.Context, spec *Spec) (*LockPackage, *Manifest, error) {
+	commit, err := gitResolve(ctx, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, commit, err := gitCheckout(ctx, spec.URL, commit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := readManifestFile(filepath.Join(dir, PACKAGE_JSON))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid git dependency %s: %w", spec.Raw, err)
+	}
+	p := &LockPackage{Name: manifest.Name, Version: manifest.Version, Resolved: gitResolved(spec.URL, commit)}
+	if _, err := s.ImportDir(ctx, dir, p.Resolved); err != nil {
+		return nil, nil, err
+	}
+	return p, manifest, nil
+}
+
+// fetchFile reads a local directory or tarball dependency and hashes its content.
+func (s *Store) fetchFile(spec *Spec) (*LockPackage, *Manifest, error) {
+	source := localPath(spec.Path)
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid file dependency %s: %w", spec.Raw, err)
+	}
+
+	var manifest *Manifest
+	var integrity string
+	if info.IsDir() {
+		if manifest, err = readManifestFile(filepath.Join(source, PACKAGE_JSON)); err != nil {
+			return nil, nil, fmt.Errorf("invalid file dependency %s: %w", spec.Raw, err)
+		}
+		integrity, err = hashDir(source)
+	} else {
+		if manifest, err = readTarballManifest(source); err != nil {
+			return nil, nil, fmt.Errorf("invalid file dependency %s: %w", spec.Raw, err)
+		}
+		integrity, err = hashFile(source)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &LockPackage{Name: manifest.Name, Version: manifest.Version, Resolved: FILE_PROTOCOL + spec.Path, Integrity: integrity}, manifest, nil
+}
+
+// fetchLink reads a linked directory, whose dependencies are left to the directory itself.
+func fetchLink(spec *Spec) (*LockPackage, *Manifest, error) {
+	manifest, err := readManifestFile(filepath.Join(localPath(spec.Path), PACKAGE_JSON))
+	if err != nil {
+		manifest = &Manifest{Name: spec.Name}
+	}
+	return &LockPackage{Name: manifest.Name, Version: manifest.Version, Resolved: LINK_PROTOCOL + spec.Path}, &Manifest{}, nil
+}
+
+// fetchTarball downloads a remote tarball, hashes it and imports it into the store.
+func (s *Store) fetchTarball(ctx context.Context, spec *Spec) (*LockPackage, *Manifest, error) {
+	tarball, err := os.CreateTemp(filepath.Join(s.Dir, "tmp"), "*.tgz")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tarball.Close()
+	defer os.Remove(tarball.Name())
+
+	integrity, err := DownloadTarball(ctx, spec.URL, spec.URL, "", tarball.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := readTarballManifest(tarball.Name())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid tarball dependency %s: %w", spec.Raw, err)
+	}
+	if _, err := s.ImportTarball(ctx, tarball.Name(), integrity); err != nil {
+		return nil, nil, err
+	}
+	return &LockPackage{Name: manifest.Name, Version: manifest.Version, Resolved: spec.URL, Integrity: integrity}, manifest, nil
+}
+
+// fetchIndex imports a locked package missing from the store.
+func (s *Store) fetchIndex(ctx context.Context, p *LockPackage) (*PackageIndex, error) {
+	spec, err := ParseDependency(p.Name, p.Resolved)
+	if err != nil {
+		return nil, err
+	}
+	switch spec.Type {
+	case SPEC_GIT:
+		dir, _, err := gitCheckout(ctx, spec.URL, spec.Committish)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		return s.ImportDir(ctx, dir, p.Resolved)
+	case SPEC_FILE:
+		source := localPath(spec.Path)
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			return s.ImportDir(ctx, source, p.Integrity)
+		}
+		return s.ImportTarball(ctx, source, p.Integrity)
+	}
+
+	tarball, err := os.CreateTemp(filepath.Join(s.Dir, "tmp"), "*.tgz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tarball.Close()
+	defer os.Remove(tarball.Name())
+
+	if _, err := DownloadTarball(ctx, p.Name, p.Resolved, p.Integrity, tarball.Name()); err != nil {
+		return nil, err
+	}
+	return s.ImportTarball(ctx, tarball.Name(), p.Integrity)
+}
+
+// storeKey returns the key a locked package is indexed under in the store.
+func (p *LockPackage) storeKey() string {
+	if p.Integrity != "" {
+		return p.Integrity
+	}
+	return p.Resolved
+}
+
+// ImportDir imports the files of a directory, except VCS metadata and
+// node_modules, into the store and indexes them under key.
+func (s *Store) ImportDir(ctx context.Context, dir, key string) (*PackageIndex, error) {
+	index := &PackageIndex{Files: map[string]IndexedFile{}}
+	err := walkPackage(dir, func(name string, path string, info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		file, err := s.writeFile(f, uint32(info.Mode().Perm()))
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", name, err)
+		}
+		index.Files[name] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if err := s.writeIndex(key, index); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// walkPackage calls fn for every regular file of a package directory, sorted.
+func walkPackage(dir string, fn func(name, path string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (d.Name() == ".git" || d.Name() == NODE_MODULE) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), path, info)
+	})
+}
+
+// hashDir returns an integrity string covering the names and contents of the files of a package directory.
+func hashDir(dir string) (string, error) {
+	h := sha512.New()
+	err := walkPackage(dir, func(name, path string, info fs.FileInfo) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		content := sha512.New()
+		if _, err := io.Copy(content, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00%x\x00", name, info.Mode().Perm()&0111, content.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+	return "sha512-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile returns the integrity string of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	verifier, _ := NewIntegrityVerifier("")
+	if _, err := io.Copy(verifier, f); err != nil {
+		return "", err
+	}
+	return verifier.Integrity(), nil
+}
+
+// readManifestFile reads the dependencies of a package.json file.
+func readManifestFile(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// readTarballManifest reads the package.json of a package tarball.
+func readTarballManifest(tarball string) (*Manifest, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("no package.json in %s", filepath.Base(tarball))
+		}
+		if name, ok := tarballEntry(header.Name); ok && name == PACKAGE_JSON {
+			var manifest Manifest
+			if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("error decoding package.json: %w", err)
+			}
+			return &manifest, nil
+		}
+	}
+}
+
+// localPath returns the absolute path of a file or link specifier path.
+func localPath(p string) string {
+	if filepath.IsAbs(filepath.FromSlash(p)) {
+		return filepath.FromSlash(p)
+	}
+	return filepath.Join(GetCwd(), filepath.FromSlash(p))
+}
+
+// gitResolved returns the resolved specifier of a git dependency pinned to a commit.
+func gitResolved(url, commit string) string {
+	if strings.HasPrefix(url, "git://") {
+		return url + "#" + commit
+	}
+	return "git+" + url + "#" + commit
+}
+
+// gitResolve resolves the committish or semver range of a git specifier to a commit using git ls-remote.
+func gitResolve(ctx context.Context, spec *Spec) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "ls-remote", "--", spec.URL).CombinedOutput()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", failure(FAILURE_NETWORK, "failed to list references of %s: %s", spec.URL, strings.TrimSpace(string(out)))
+	}
+
+	// Peeled annotated tags (^{}) point to the commit rather than the tag object
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		commit, ref, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if peeled, ok := strings.CutSuffix(ref, "^{}"); ok {
+			refs[peeled] = commit
+		} else if _, ok := refs[ref]; !ok {
+			refs[ref] = commit
+		}
+	}
+
+	switch {
+	case spec.SemverRange != "":
+		tags := map[string]string{}
+		for ref, commit := range refs {
+			if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+				tags[strings.TrimPrefix(tag, "v")] = commit
+			}
+		}
+		versions := slices.Collect(maps.Keys(tags))
+		version := MaxSatisfying(versions, spec.SemverRange)
+		if version == "" {
+			return "", failure(FAILURE_NOT_FOUND, "No tag of %s satisfies %s", spec.URL, spec.SemverRange)
+		}
+		return tags[version], nil
+	case spec.Committish == "":
+		if commit, ok := refs["HEAD"]; ok {
+			return commit, nil
+		}
+		return "", failure(FAILURE_NOT_FOUND, "No HEAD in %s", spec.URL)
+	}
+	for _, ref := range []string{"refs/tags/" + spec.Committish, "refs/heads/" + spec.Committish, spec.Committish} {
+		if commit, ok := refs[ref]; ok {
+			return commit, nil
+		}
+	}
+	if commitRegexp.MatchString(spec.Committish) {
+		return spec.Committish, nil
+	}
+	return "", failure(FAILURE_NOT_FOUND, "No reference %s in %s", spec.Committish, spec.URL)
+}
+
+// gitCheckout clones a repository in a temporary directory at a commit and returns the full commit hash.
+func gitCheckout(ctx context.Context, url, commit string) (string, string, error) {
+	if !commitRegexp.MatchString(commit) {
+		return "", "", fmt.Errorf("invalid commit %q for %s", commit, url)
+	}
+	dir, err := os.MkdirTemp("", "gopm-git-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	for _, args := range [][]string{
+		{"clone", "--quiet", "--", url, dir},
+		{"-C", dir, "checkout", "--quiet", commit},
+	} {
+		if out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			if ctx.Err() != nil {
+				return "", "", ctx.Err()
+			}
+			return "", "", failure(FAILURE_NETWORK, "failed to checkout %s#%s: %s", url, commit, strings.TrimSpace(string(out)))
+		}
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("failed to checkout %s#%s: %w", url, commit, err)
+	}
+	return dir, strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// git runs a git command in dir and fails the test on error.
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@test", "-c", "commit.gpgsign=false"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s", args, out)
+	}
+}
+
+// TestResolveExternal ensures git and file dependencies are pinned and their dependencies resolved
+func TestResolveExternal(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store"), "package-import-method": IMPORT_COPY})
+	assert.NoError(t, err, "should open store")
+
+	// A repository with two tagged versions, the latest depending on the registry
+	repo := filepath.Join(dir, "repo")
+	assert.NoError(t, os.MkdirAll(repo, 0755))
+	git(t, repo, "init", "--quiet")
+	for _, release := range []struct{ version, manifest string }{
+		{"1.0.0", `{"name":"gitdep","version":"1.0.0"}`},
+		{"1.1.0", `{"name":"gitdep","version":"1.1.0","dependencies":{"c":"^1.0.0"}}`},
+		{"2.0.0", `{"name":"gitdep","version":"2.0.0"}`},
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(repo, PACKAGE_JSON), []byte(release.manifest), 0644))
+		git(t, repo, "add", "-A")
+		git(t, repo, "commit", "--quiet", "-m", release.version)
+		git(t, repo, "tag", "-a", "v"+release.version, "-m", release.version)
+	}
+
+	local := filepath.Join(dir, "local")
+	assert.NoError(t, os.MkdirAll(local, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(local, PACKAGE_JSON), []byte(`{"name":"localdep","version":"0.1.0"}`), 0644))
+
+	registry := fakeRegistry{"c": {"1.0.0": nil}}
+	resolver := NewResolver(nil)
+	resolver.Fetch = registry.fetch
+	resolver.Store = store
+	lock, err := resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{
+		"gitdep":   "git+file://" + filepath.ToSlash(repo) + "#semver:^1.0",
+		"localdep": "file:" + filepath.ToSlash(local),
+	}}, nil)
+	assert.NoError(t, err, "should resolve the dependency graph")
+
+	gitKey := lock.Importers[ROOT_IMPORTER].Dependencies["gitdep"].Package
+	p := lock.Packages[gitKey]
+	assert.Equal(t, "1.1.0", p.Version, "should pick the highest tag satisfying the range")
+	assert.Regexp(t, `^git\+file://.*#[0-9a-f]{40}$`, p.Resolved, "should pin the commit")
+	assert.Equal(t, "c@1.0.0", p.Dependencies["c"].Package, "should resolve the dependencies of the git package")
+	_, ok := store.Index(p.Resolved)
+	assert.True(t, ok, "git package should be imported into the store")
+
+	fileKey := lock.Importers[ROOT_IMPORTER].Dependencies["localdep"].Package
+	assert.Equal(t, "0.1.0", lock.Packages[fileKey].Version)
+	assert.NotEmpty(t, lock.Packages[fileKey].Integrity, "file package should be hashed")
+
+	// The locked commit is reused without listing the repository again
+	relock := NewResolver(lock)
+	relock.Fetch = registry.fetch
+	relocked, err := relock.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{
+		"gitdep": "git+file://" + filepath.ToSlash(repo) + "#semver:^1.0",
+	}}, nil)
+	assert.NoError(t, err, "should resolve from the lockfile without a store")
+	assert.Equal(t, gitKey, relocked.Importers[ROOT_IMPORTER].Dependencies["gitdep"].Package)
+	assert.Contains(t, relocked.Packages, "c@1.0.0", "should keep the dependencies of the locked git package")
+}
+
+// TestResolveLocalPaths ensures file and link paths are relative to the package.json declaring them
+func TestResolveLocalPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store"), "package-import-method": IMPORT_COPY})
+	assert.NoError(t, err, "should open store")
+	for _, name := range []string{"shared", "lib", "tools"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "packages", name), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "packages", name, PACKAGE_JSON), []byte(`{"name":"`+name+`","version":"1.0.0"}`), 0644))
+	}
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, PACKAGE_JSON), []byte(`{"name":"outside","version":"1.0.0"}`), 0644))
+
+	resolver := NewResolver(nil)
+	resolver.Store = store
+	lock, err := resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{"tools": "file:packages/tools"}}, []Workspace{{
+		Dir: "packages/app",
+		Manifest: &PackageJSON{Name: "app", Version: "1.0.0", Dependencies: map[string]string{
+			"shared":  "file:../shared",
+			"lib":     "link:../lib",
+			"outside": "link:" + filepath.ToSlash(outside),
+		}},
+	}})
+	assert.NoError(t, err)
+	resolved := func(importer, name string) string {
+		return lock.Packages[lock.Importers[importer].Dependencies[name].Package].Resolved
+	}
+	assert.Equal(t, "file:packages/tools", resolved(ROOT_IMPORTER, "tools"))
+	assert.Equal(t, "file:packages/shared", resolved("packages/app", "shared"))
+	assert.Equal(t, "link:packages/lib", resolved("packages/app", "lib"))
+
+	// Absolute link targets are kept as they are
+	tx, err := BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, CreateLinks(Links{"node_modules/outside": filepath.ToSlash(outside), "node_modules/lib": "packages/lib"}, tx))
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, "1.0.0", InstalledVersion(filepath.Join(dir, "node_modules", "outside")))
+	assert.Equal(t, "1.0.0", InstalledVersion(filepath.Join(dir, "node_modules", "lib")))
+}
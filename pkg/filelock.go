@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LOCKS_DIR          = "locks"
+	STORE_LOCK         = "lock"
+	LOCK_TIMEOUT       = "5m"
+	LOCK_POLL_INTERVAL = 100 * time.Millisecond
+)
+
+// ErrLocked is returned when a lock is still held by another gopm process once the lock timeout expires.
+var ErrLocked = errors.New("locked by another gopm process")
+
+// FileLock is an advisory lock on a file, held until it is released or the process exits.
+type FileLock struct {
+	file      *os.File
+	exclusive bool
+}
+
+// AcquireLock locks a file, creating it when needed. Exclusive locks exclude any other
+// lock while shared locks only exclude exclusive ones. When the file is locked by another
+// process, AcquireLock waits for it up to timeout, a zero timeout failing immediately,
+// or until ctx is cancelled.
+func AcquireLock(ctx context.Context, path string, exclusive bool, timeout time.Duration, what string) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the lock of %s: %w", what, err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock of %s: %w", what, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for waiting := false; ; waiting = true {
+		locked, err := tryLockFile(file, exclusive)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", what, err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			if timeout == 0 {
+				return nil, fmt.Errorf("%s is %w%s", what, ErrLocked, lockHolder(path))
+			}
+			return nil, fmt.Errorf("%s is still %w%s after %v, set lock-timeout to wait longer", what, ErrLocked, lockHolder(path), timeout)
+		}
+		if !waiting {
+			logrus.Warnf("Waiting for another gopm process%s to release %s", lockHolder(path), what)
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(LOCK_POLL_INTERVAL):
+		}
+	}
+
+	// The pid of the holder of an exclusive lock is shown to the processes waiting for it
+	if exclusive {
+		if err := file.Truncate(0); err == nil {
+			file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		}
+	}
+	return &FileLock{file: file, exclusive: exclusive}, nil
+}
+
+// lockHolder describes the process holding a lock file, empty when it is unknown.
+func lockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		return fmt.Sprintf(" (pid %d)", pid)
+	}
+	return ""
+}
+
+// Release unlocks the file. Releasing a nil lock does nothing.
+func (l *FileLock) Release() error {
+	if l == nil {
+		return nil
+	}
+	if l.exclusive {
+		l.file.Truncate(0)
+	}
+	unlockFile(l.file)
+	return l.file.Close()
+}
+
+// LockTimeout returns how long gopm waits for another gopm process to release a
+// lock, from the lock-timeout setting: a duration such as 30s or 2m, or a bare
+// number of seconds. Zero fails immediately.
+func (c Config) LockTimeout() (time.Duration, error) {
+	value := strings.TrimSpace(c.Get("lock-timeout", LOCK_TIMEOUT))
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lock-timeout %q, expected a duration such as 30s or 2m", value)
+	}
+	return timeout, nil
+}
+
+// LockProject takes the exclusive lock of the project, so that a single gopm process
+// at a time changes its node_modules, package.json and lockfile. Locks live in
+// ~/.gopm/locks, named after a hash of the project directory.
+func LockProject(ctx context.Context, config Config) (*FileLock, error) {
+	timeout, err := config.LockTimeout()
+	if err != nil {
+		return nil, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the home directory: %w", err)
+	}
+	cwd := GetCwd()
+	if resolved, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = resolved
+	}
+	hash := sha256.Sum256([]byte(cwd))
+	path := filepath.Join(home, ".gopm", LOCKS_DIR, hex.EncodeToString(hash[:8])+".lock")
+	return AcquireLock(ctx, path, true, timeout, "the project "+cwd)
+}
+
+// Lock takes a lock on the store: shared while installs import packages from it,
+// exclusive while it is pruned.
+func (s *Store) Lock(ctx context.Context, config Config, exclusive bool) (*FileLock, error) {
+	timeout, err := config.LockTimeout()
+	if err != nil {
+		return nil, err
+	}
+	return AcquireLock(ctx, filepath.Join(s.Dir, STORE_LOCK), exclusive, timeout, "the store "+s.Dir)
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileLock ensures a second gopm process waits for the lock of the project and store, or fails fast
+func TestFileLock(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Chdir(dir)
+	ctx := t.Context()
+
+	project, err := LockProject(ctx, Config{})
+	assert.NoError(t, err)
+	_, err = LockProject(ctx, Config{"lock-timeout": "0"})
+	assert.ErrorIs(t, err, ErrLocked, "a zero lock-timeout fails fast")
+	assert.Contains(t, err.Error(), fmt.Sprintf("(pid %d)", os.Getpid()))
+
+	start := time.Now()
+	_, err = LockProject(ctx, Config{"lock-timeout": "300ms"})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	// Interrupting gopm stops the wait
+	cancelled, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = LockProject(cancelled, Config{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute, "should not wait for the lock timeout")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		project.Release()
+	}()
+	project, err = LockProject(ctx, Config{})
+	assert.NoError(t, err, "the lock is acquired once released")
+	assert.NoError(t, project.Release())
+
+	store := &Store{Dir: filepath.Join(dir, "store")}
+	installs := []*FileLock{}
+	for range 2 {
+		lock, err := store.Lock(ctx, Config{"lock-timeout": "0"}, false)
+		assert.NoError(t, err, "installs share the store")
+		installs = append(installs, lock)
+	}
+	_, err = store.Lock(ctx, Config{"lock-timeout": "0"}, true)
+	assert.ErrorIs(t, err, ErrLocked, "the store is not pruned while installs use it")
+	for _, lock := range installs {
+		assert.NoError(t, lock.Release())
+	}
+	prune, err := store.Lock(ctx, Config{"lock-timeout": "0"}, true)
+	assert.NoError(t, err)
+	_, err = store.Lock(ctx, Config{"lock-timeout": "0"}, false)
+	assert.ErrorIs(t, err, ErrLocked, "installs wait for the store to be pruned")
+	assert.NoError(t, prune.Release())
+
+	_, err = LockProject(ctx, Config{"lock-timeout": "soon"})
+	assert.Error(t, err)
+	timeout, err := Config{"lock-timeout": "30"}.LockTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout, "a bare number is a number of seconds")
+	timeout, err = Config{}.LockTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, timeout)
+
+	// The --lock-timeout flag overrides the setting without changing the environment of scripts
+	t.Setenv("npm_config_lock_timeout", "10s")
+	SetConfig("lock-timeout", "45s")
+	t.Cleanup(func() { delete(overrides, "lock-timeout") })
+	timeout, err = LoadConfig().LockTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, timeout)
+	assert.Equal(t, "10s", os.Getenv("npm_config_lock_timeout"))
+}
@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the byte range locked in lock files. It lies past their content so
+// that the pid written in them stays readable while they are locked.
+var lockRange = windows.Overlapped{Offset: 0xFFFFFFFE, OffsetHigh: 0x7FFFFFFF}
+
+// tryLockFile locks a file without blocking, returning false when another process holds a conflicting lock.
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	overlapped := lockRange
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &overlapped)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION):
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock of a file.
+func unlockFile(file *os.File) error {
+	overlapped := lockRange
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &overlapped)
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// IntegrityVerifier computes the digest of the data written to it and
+// compares it against a Subresource Integrity string (sha512-...).
+type IntegrityVerifier struct {
+	hash.Hash
+	algorithm string
+	expected  []byte
+}
+
+// NewIntegrityVerifier creates a verifier for an SRI string or a hex sha1 shasum.
+// An empty integrity yields a verifier which computes a sha512 digest without checking it.
+func NewIntegrityVerifier(integrity string) (*IntegrityVerifier, error) {
+	integrity = strings.TrimSpace(integrity)
+	if integrity == "" {
+		return &IntegrityVerifier{Hash: sha512.New(), algorithm: "sha512"}, nil
+	}
+	// Legacy packages only publish a hex encoded sha1 shasum
+	if !strings.Contains(integrity, "-") {
+		expected, err := hex.DecodeString(integrity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shasum %q", integrity)
+		}
+		return &IntegrityVerifier{Hash: sha1.New(), algorithm: "sha1", expected: expected}, nil
+	}
+
+	// Several hashes may be listed, the strongest one is used
+	var best *IntegrityVerifier
+	for _, entry := range strings.Fields(integrity) {
+		algorithm, digest, _ := strings.Cut(entry, "-")
+		expected, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integrity %q", entry)
+		}
+		var h hash.Hash
+		switch algorithm {
+		case "sha512":
+			h = sha512.New()
+		case "sha384":
+			h = sha512.New384()
+		case "sha256":
+			h = sha256.New()
+		case "sha1":
+			h = sha1.New()
+		default:
+			continue
+		}
+		if best == nil || h.Size() > best.Size() {
+			best = &IntegrityVerifier{Hash: h, algorithm: algorithm, expected: expected}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("unsupported integrity %q", integrity)
+	}
+	return best, nil
+}
+
+// Integrity returns the SRI string of the data written so far.
+func (v *IntegrityVerifier) Integrity() string {
+	return v.algorithm + "-" + base64.StdEncoding.EncodeToString(v.Sum(nil))
+}
+
+// Verify reports an error when the digest does not match the expected one.
+func (v *IntegrityVerifier) Verify() error {
+	if v.expected == nil {
+		return nil
+	}
+	if actual := v.Sum(nil); string(actual) != string(v.expected) {
+		return fmt.Errorf("integrity mismatch: expected %s-%s, got %s",
+			v.algorithm, base64.StdEncoding.EncodeToString(v.expected), v.Integrity())
+	}
+	return nil
+}
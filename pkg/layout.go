@@ -0,0 +1,260 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	LINKER_HOISTED  = "hoisted"
+	LINKER_ISOLATED = "isolated"
+	VIRTUAL_STORE   = ".gopm"
+)
+
+// Layout maps node_modules locations, relative to the project, to lockfile package keys.
+type Layout map[string]string
+
+// Links maps symlink locations, relative to the project, to the locations they point to.
+type Links map[string]string
+
+// layoutItem is a placed package (or a workspace) whose dependencies are waiting to be placed.
+type layoutItem struct {
+	location string
+	edges    []NamedDependency
+}
+
+// hoister plans a hoisted layout.
+type hoister struct {
+	lock   *Lockfile
+	layout Layout
+	// importers are the workspaces by location
+	importers map[string]*LockImporter
+}
+
+// PlanHoisted plans a hoisted node_modules layout for the lockfile. Every
+// package is placed as close to the top-level node_modules as possible and
+// conflicting versions are nested under node_modules/<parent>/node_modules/<dep>,
+// so that Node's resolution algorithm finds the right version for each dependent.
+// Workspace packages are symlinked at the top level so they can import each other.
+func PlanHoisted(lock *Lockfile) (Layout, Links) {
+	h := &hoister{lock: lock, layout: Layout{}, importers: map[string]*LockImporter{}}
+	queue := []layoutItem{}
+
+	// Direct dependencies always live at the top level
+	for _, edge := range lock.Importers[ROOT_IMPORTER].Edges() {
+		if p, ok := lock.Packages[edge.Package]; ok {
+			location := modulePath("", edge.Name)
+			h.layout[location] = edge.Package
+			queue = append(queue, layoutItem{location, p.Edges()})
+		}
+	}
+
+	// Dependencies of workspaces are placed before transitive dependencies
+	workspaces := []layoutItem{}
+	for _, dir := range slices.Sorted(maps.Keys(lock.Importers)) {
+		if dir == ROOT_IMPORTER {
+			continue
+		}
+		h.importers[dir] = lock.Importers[dir]
+		workspaces = append(workspaces, layoutItem{dir, lock.Importers[dir].Edges()})
+		for key, p := range lock.Packages {
+			if target, ok := p.linkTarget(); ok && target == dir {
+				if _, taken := h.layout[modulePath("", p.Name)]; !taken {
+					h.layout[modulePath("", p.Name)] = key
+				}
+			}
+		}
+	}
+	queue = append(workspaces, queue...)
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		for _, edge := range item.edges {
+			p, ok := lock.Packages[edge.Package]
+			if !ok {
+				continue
+			}
+			if found, ok := h.layout.Lookup(item.location, edge.Name); ok && h.layout[found] == edge.Package {
+				continue
+			}
+			location := modulePath(h.hoistTarget(item.location, edge.Name, edge.Package), edge.Name)
+			h.layout[location] = edge.Package
+			// Dependencies of workspaces are placed from the workspace itself
+			if _, ok := p.linkTarget(); !ok {
+				queue = append(queue, layoutItem{location, p.Edges()})
+			}
+		}
+	}
+	return h.layout.splitLinks(lock)
+}
+
+// splitLinks moves the link packages of a layout into symlinks to their directory.
+func (l Layout) splitLinks(lock *Lockfile) (Layout, Links) {
+	links := Links{}
+	for location, key := range l {
+		if target, ok := lock.Packages[key].linkTarget(); ok {
+			links[location] = target
+			delete(l, location)
+		}
+	}
+	return l, links
+}
+
+// PlanIsolated plans an isolated node_modules layout for the lockfile. Every
+// package is stored once in node_modules/.gopm/<name>@<version>/node_modules/<name>
+// and only sees its declared dependencies, symlinked next to it.
+func PlanIsolated(lock *Lockfile) (Layout, Links) {
+	layout, links := Layout{}, Links{}
+	for key, p := range lock.Packages {
+		if _, ok := p.linkTarget(); ok {
+			continue
+		}
+		location := isolatedPath(key, p.Name)
+		layout[location] = key
+		for _, edge := range p.Edges() {
+			link := modulePath(parentLocation(location), edge.Name)
+			if target, ok := isolatedTarget(lock, edge); ok && link != location {
+				links[link] = target
+			}
+		}
+	}
+	for dir, importer := range lock.Importers {
+		for _, edge := range importer.Edges() {
+			if target, ok := isolatedTarget(lock, edge); ok {
+				links[modulePath(importerLocation(dir), edge.Name)] = target
+			}
+		}
+	}
+	return layout, links
+}
+
+// isolatedTarget returns the location a dependency is symlinked to in an isolated layout.
+func isolatedTarget(lock *Lockfile, edge NamedDependency) (string, bool) {
+	p, ok := lock.Packages[edge.Package]
+	if !ok {
+		return "", false
+	}
+	if target, ok := p.linkTarget(); ok {
+		return target, true
+	}
+	return isolatedPath(edge.Package, p.Name), true
+}
+
+// PlanLayout plans the node_modules layout of the lockfile for a node-linker setting.
+func PlanLayout(lock *Lockfile, linker string) (Layout, Links, error) {
+	switch linker {
+	case LINKER_HOISTED:
+		layout, links := PlanHoisted(lock)
+		return layout, links, nil
+	case LINKER_ISOLATED:
+		layout, links := PlanIsolated(lock)
+		return layout, links, nil
+	}
+	return nil, nil, fmt.Errorf("unknown node-linker %q, expected %s or %s", linker, LINKER_HOISTED, LINKER_ISOLATED)
+}
+
+// isolatedPath returns the location of a package in the virtual store.
+func isolatedPath(key, name string) string {
+	return modulePath(path.Join(NODE_MODULE, VIRTUAL_STORE, strings.ReplaceAll(key, "/", "+")), name)
+}
+
+// hoistTarget returns the highest directory, between the dependent and the
+// project root, where a package can be placed without conflicting with another
+// version of the same name or shadowing the one used by another dependent.
+func (h *hoister) hoistTarget(from, name, key string) string {
+	target := from
+	for dir := from; ; dir = parentLocation(dir) {
+		if existing, ok := h.layout[modulePath(dir, name)]; ok && existing != key {
+			break
+		}
+		if h.shadows(dir, name, key) {
+			break
+		}
+		target = dir
+		if dir == "" {
+			break
+		}
+	}
+	return target
+}
+
+// shadows reports whether placing a package in dir would hide another version
+// of the same name that a dependent located below dir currently resolves higher up.
+func (h *hoister) shadows(dir, name, key string) bool {
+	dependents := slices.Collect(maps.Keys(h.layout))
+	dependents = slices.AppendSeq(dependents, maps.Keys(h.importers))
+	for _, location := range dependents {
+		if dir != "" && location != dir && !strings.HasPrefix(location, dir+"/") {
+			continue
+		}
+		dep, ok := h.edge(location, name)
+		if !ok || dep.Package == key {
+			continue
+		}
+		found, ok := h.layout.Lookup(location, name)
+		if !ok {
+			continue
+		}
+		if parent := parentLocation(found); parent != dir && !strings.HasPrefix(parent, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// edge returns the dependency edge of the dependent at location for a given name.
+func (h *hoister) edge(location, name string) (LockDependency, bool) {
+	if importer, ok := h.importers[location]; ok {
+		return importer.Edge(name)
+	}
+	p := h.lock.Packages[h.layout[location]]
+	if _, ok := p.linkTarget(); ok {
+		return LockDependency{}, false
+	}
+	if dep, ok := p.Dependencies[name]; ok {
+		return dep, true
+	}
+	dep, ok := p.OptionalDependencies[name]
+	return dep, ok
+}
+
+// Lookup returns the location Node resolves a dependency to when required
+// from the package at location, walking up the node_modules folders.
+func (l Layout) Lookup(location, name string) (string, bool) {
+	for dir := location; ; dir = parentLocation(dir) {
+		candidate := modulePath(dir, name)
+		if _, ok := l[candidate]; ok {
+			return candidate, true
+		}
+		if dir == "" {
+			return "", false
+		}
+	}
+}
+
+// Locations returns the locations of every package key, sorted.
+func (l Layout) Locations() map[string][]string {
+	locations := map[string][]string{}
+	for _, location := range slices.Sorted(maps.Keys(l)) {
+		locations[l[location]] = append(locations[l[location]], location)
+	}
+	return locations
+}
+
+// modulePath returns the location of a dependency installed in the node_modules folder of dir.
+func modulePath(dir, name string) string {
+	return path.Join(dir, NODE_MODULE, name)
+}
+
+// parentLocation returns the location of the package whose node_modules folder contains location.
+func parentLocation(location string) string {
+	index := strings.LastIndex(location, "/"+NODE_MODULE+"/")
+	if index < 0 {
+		return ""
+	}
+	return location[:index]
+}
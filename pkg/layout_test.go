@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is an in-memory registry: name -> version -> dependencies.
+type fakeRegistry map[string]map[string]map[string]string
+
+// fetch returns the package document of a dependency of the fake registry.
+func (f fakeRegistry) fetch(ctx context.Context, dependency string) (*BodyRegistery, error) {
+	versions, ok := f[dependency]
+	if !ok {
+		return nil, fmt.Errorf("Package %s not found in the registry", dependency)
+	}
+	body := &BodyRegistery{Name: dependency, Versions: map[string]Manifest{}}
+	for version, dependencies := range versions {
+		body.Versions[version] = Manifest{
+			Name:         dependency,
+			Version:      version,
+			Dependencies: dependencies,
+			Dist:         Dist{Tarball: fmt.Sprintf("https://registry.test/%s-%s.tgz", dependency, version)},
+		}
+	}
+	all := slices.Collect(maps.Keys(versions))
+	SortVersions(all)
+	body.DistTags = map[string]string{LATEST_TAG: all[len(all)-1]}
+	return body, nil
+}
+
+// resolve resolves a package.json against the fake registry.
+func (f fakeRegistry) resolve(t *testing.T, p *PackageJSON, workspaces ...Workspace) *Lockfile {
+	resolver := NewResolver(nil)
+	resolver.Fetch = f.fetch
+	lock, err := resolver.Resolve(t.Context(), p, workspaces)
+	assert.NoError(t, err, "should resolve the dependency graph")
+	return lock
+}
+
+// TestPlanHoistedNestsConflicts ensures conflicting versions are nested under their dependent
+func TestPlanHoistedNestsConflicts(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"c": "^1.0.0"}},
+		"b": {"1.0.0": {"c": "^2.0.0"}},
+		"c": {"1.0.0": nil, "2.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"}})
+
+	layout, links := PlanHoisted(lock)
+	assert.Equal(t, Layout{
+		"node_modules/a":                "a@1.0.0",
+		"node_modules/b":                "b@1.0.0",
+		"node_modules/c":                "c@1.0.0",
+		"node_modules/b/node_modules/c": "c@2.0.0",
+	}, layout)
+	assert.Empty(t, links)
+}
+
+// TestPlanHoistedDirectDependenciesWin ensures direct dependencies keep the top level
+func TestPlanHoistedDirectDependenciesWin(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"c": "^1.0.0"}},
+		"c": {"1.0.0": nil, "2.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{
+		Dependencies:    map[string]string{"a": "^1.0.0"},
+		DevDependencies: map[string]string{"c": "^2.0.0"},
+	})
+
+	layout, _ := PlanHoisted(lock)
+	assert.Equal(t, "c@2.0.0", layout["node_modules/c"])
+	assert.Equal(t, "c@1.0.0", layout["node_modules/a/node_modules/c"])
+	assert.False(t, lock.Packages["c@1.0.0"].Dev, "c@1.0.0 is a production dependency")
+	assert.True(t, lock.Packages["c@2.0.0"].Dev, "c@2.0.0 is only a dev dependency")
+}
+
+// TestPlanHoistedAvoidsShadowing ensures a hoisted package never hides the version a sibling resolves
+func TestPlanHoistedAvoidsShadowing(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"x": "^1.0.0", "y": "^1.0.0"}},
+		"c": {"1.0.0": nil, "2.0.0": nil},
+		"x": {"1.0.0": {"c": "^2.0.0"}, "2.0.0": nil},
+		"y": {"1.0.0": {"c": "^1.0.0"}, "2.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{
+		"a": "^1.0.0", "c": "^2.0.0", "x": "^2.0.0", "y": "^2.0.0",
+	}})
+
+	layout, _ := PlanHoisted(lock)
+	assert.Equal(t, "c@1.0.0", layout["node_modules/a/node_modules/y/node_modules/c"])
+	assert.NotContains(t, layout, "node_modules/a/node_modules/c")
+	for location, key := range layout {
+		for _, edge := range lock.Packages[key].Edges() {
+			found, ok := layout.Lookup(location, edge.Name)
+			assert.True(t, ok, "%s should find %s", location, edge.Name)
+			assert.Equal(t, edge.Package, layout[found], "%s should resolve %s to the locked version", location, edge.Name)
+		}
+	}
+}
+
+// TestPlanIsolated ensures every package only sees its declared dependencies
+func TestPlanIsolated(t *testing.T) {
+	registry := fakeRegistry{
+		"a":      {"1.0.0": {"@s/b": "^1.0.0"}},
+		"@s/b":   {"1.0.0": {"c": "^1.0.0"}},
+		"c":      {"1.0.0": nil},
+		"unused": {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}})
+
+	layout, links := PlanIsolated(lock)
+	assert.Equal(t, Layout{
+		"node_modules/.gopm/a@1.0.0/node_modules/a":       "a@1.0.0",
+		"node_modules/.gopm/@s+b@1.0.0/node_modules/@s/b": "@s/b@1.0.0",
+		"node_modules/.gopm/c@1.0.0/node_modules/c":       "c@1.0.0",
+	}, layout)
+	assert.Equal(t, Links{
+		"node_modules/a": "node_modules/.gopm/a@1.0.0/node_modules/a",
+		"node_modules/.gopm/a@1.0.0/node_modules/@s/b": "node_modules/.gopm/@s+b@1.0.0/node_modules/@s/b",
+		"node_modules/.gopm/@s+b@1.0.0/node_modules/c": "node_modules/.gopm/c@1.0.0/node_modules/c",
+	}, links)
+}
+
+// TestPlanHoistedWorkspaces ensures workspaces are linked and their dependencies hoisted
+func TestPlanHoistedWorkspaces(t *testing.T) {
+	registry := fakeRegistry{
+		"c": {"1.0.0": nil, "2.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{}, Workspace{
+		Dir:      "packages/a",
+		Manifest: &PackageJSON{Name: "a", Version: "1.0.0", Dependencies: map[string]string{"b": "workspace:*", "c": "^1.0.0"}},
+	}, Workspace{
+		Dir:      "packages/b",
+		Manifest: &PackageJSON{Name: "b", Version: "2.0.0", Dependencies: map[string]string{"c": "^2.0.0"}},
+	})
+	assert.Equal(t, LockDependency{Specifier: "workspace:*", Package: "b@link:packages/b"}, lock.Importers["packages/a"].Dependencies["b"])
+
+	layout, links := PlanHoisted(lock)
+	assert.Equal(t, Layout{
+		"node_modules/c":            "c@1.0.0",
+		"packages/b/node_modules/c": "c@2.0.0",
+	}, layout)
+	assert.Equal(t, Links{
+		"node_modules/a": "packages/a",
+		"node_modules/b": "packages/b",
+	}, links)
+
+	layout, links = PlanIsolated(lock)
+	assert.Len(t, layout, 2)
+	assert.Equal(t, Links{
+		"packages/a/node_modules/b": "packages/b",
+		"packages/a/node_modules/c": "node_modules/.gopm/c@1.0.0/node_modules/c",
+		"packages/b/node_modules/c": "node_modules/.gopm/c@2.0.0/node_modules/c",
+	}, links)
+}
+
+// TestPlanHoistedAliases ensures aliased dependencies are installed under their alias
+func TestPlanHoistedAliases(t *testing.T) {
+	registry := fakeRegistry{
+		"preact": {"10.0.0": nil, "10.1.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"react": "npm:preact@~10.0.0", "preact": "^10.0.0"}})
+
+	layout, _ := PlanHoisted(lock)
+	assert.Equal(t, Layout{
+		"node_modules/react":  "preact@10.0.0",
+		"node_modules/preact": "preact@10.1.0",
+	}, layout)
+}
+
+// TestResolveImporterSections ensures optional and peer dependencies of a project are installed
+func TestResolveImporterSections(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": nil},
+		"b": {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{
+		OptionalDependencies: map[string]string{"a": "^1.0.0", "missing": "^1.0.0"},
+		PeerDependencies:     map[string]string{"b": "^1.0.0"},
+	})
+
+	layout, _ := PlanHoisted(lock)
+	assert.Equal(t, Layout{"node_modules/a": "a@1.0.0", "node_modules/b": "b@1.0.0"}, layout)
+	assert.True(t, lock.Packages["a@1.0.0"].Optional, "a is only an optional dependency")
+	assert.False(t, lock.Packages["b@1.0.0"].Dev, "peer dependencies are production dependencies")
+}
+
+// TestLockfileOmit ensures omitted dependency types and their exclusive dependencies are left out
+func TestLockfileOmit(t *testing.T) {
+	registry := fakeRegistry{
+		"a":      {"1.0.0": {"shared": "^1.0.0"}},
+		"jest":   {"1.0.0": {"shared": "^1.0.0", "chalk": "^1.0.0"}},
+		"fsev":   {"1.0.0": nil},
+		"shared": {"1.0.0": nil},
+		"chalk":  {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{
+		Dependencies:         map[string]string{"a": "^1.0.0"},
+		DevDependencies:      map[string]string{"jest": "^1.0.0"},
+		OptionalDependencies: map[string]string{"fsev": "^1.0.0"},
+	})
+
+	layout, _ := PlanHoisted(lock.Omit([]string{OMIT_DEV, OMIT_OPTIONAL}))
+	assert.Equal(t, Layout{"node_modules/a": "a@1.0.0", "node_modules/shared": "shared@1.0.0"}, layout)
+	assert.Len(t, lock.Packages, 5, "the lockfile should be left intact")
+
+	// Skipped packages leave out the packages only they require, such as chalk
+	layout, _ = PlanHoisted(lock.Without([]string{"jest@1.0.0", "fsev@1.0.0"}))
+	assert.Equal(t, Layout{"node_modules/a": "a@1.0.0", "node_modules/shared": "shared@1.0.0"}, layout)
+	assert.Len(t, lock.Packages, 5, "the lockfile should be left intact")
+
+	_, err := ParseOmit([]string{"dev,optional", "test"})
+	assert.Error(t, err, "unknown dependency types should be rejected")
+}
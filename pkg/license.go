@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	LICENSE_UNKNOWN = "UNKNOWN"
+	SEE_LICENSE_IN  = "SEE LICENSE IN "
+)
+
+// licenseFilePrefixes are the names license files start with.
+var licenseFilePrefixes = []string{"LICENSE", "LICENCE", "COPYING"}
+
+// licenseDetectors recognize the license of license files missing from package.json,
+// the first detector whose phrases all appear in the file wins.
+var licenseDetectors = []struct {
+	license string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "Version 2.0"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"Unlicense", []string{"This is free and unencumbered software released into the public domain"}},
+}
+
+// PackageLicense is the license of an installed package.
+type PackageLicense struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// License is an SPDX expression, or UNKNOWN
+	License string `json:"license"`
+	// Files are the license files of the package, relative to the project
+	Files []string `json:"files,omitempty"`
+}
+
+// LicensePolicy restricts the licenses allowed in node_modules. An empty allow list
+// allows every license which is not denied.
+type LicensePolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// LicensePolicy returns the policy of the license-allow and license-deny settings,
+// lists of licenses separated by spaces or commas.
+func (c Config) LicensePolicy() LicensePolicy {
+	return LicensePolicy{Allow: c.List("license-allow"), Deny: c.List("license-deny")}
+}
+
+// Enabled returns whether the policy restricts any license.
+func (p LicensePolicy) Enabled() bool {
+	return len(p.Allow) > 0 || len(p.Deny) > 0
+}
+
+// Allows returns whether an SPDX expression is allowed: one alternative of an OR
+// expression must be allowed, along with every license of an AND expression.
+func (p LicensePolicy) Allows(expression string) bool {
+	expression = strings.NewReplacer("(", " ", ")", " ").Replace(expression)
+	for _, alternative := range splitOperator(expression, "OR") {
+		allowed := true
+		for _, license := range splitOperator(alternative, "AND") {
+			allowed = allowed && p.allowsLicense(license)
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsLicense returns whether a single license identifier is allowed.
+func (p LicensePolicy) allowsLicense(license string) bool {
+	license, _, _ = strings.Cut(license, " WITH ")
+	matches := func(id string) bool { return strings.EqualFold(id, strings.TrimSpace(license)) }
+	if slices.ContainsFunc(p.Deny, matches) {
+		return false
+	}
+	return len(p.Allow) == 0 || slices.ContainsFunc(p.Allow, matches)
+}
+
+// splitOperator splits an SPDX expression on an operator, ignoring its case.
+func splitOperator(expression, operator string) []string {
+	parts := []string{}
+	current := []string{}
+	for _, word := range strings.Fields(expression) {
+		if strings.EqualFold(word, operator) {
+			parts = append(parts, strings.Join(current, " "))
+			current = []string{}
+			continue
+		}
+		current = append(current, word)
+	}
+	return append(parts, strings.Join(current, " "))
+}
+
+// Violations returns the licenses which the policy does not allow.
+func (p LicensePolicy) Violations(licenses []PackageLicense) []PackageLicense {
+	violations := []PackageLicense{}
+	for _, license := range licenses {
+		if !p.Allows(license.License) {
+			violations = append(violations, license)
+		}
+	}
+	return violations
+}
+
+// Licenses returns the license of every package of a layout installed in node_modules, sorted by package.
+func (l *Lockfile) Licenses(layout Layout) ([]PackageLicense, error) {
+	cwd := GetCwd()
+	licenses := []PackageLicense{}
+	for key, locations := range layout.Locations() {
+		p, ok := l.Packages[key]
+		if !ok {
+			continue
+		}
+		license, files, err := ReadLicense(filepath.Join(cwd, filepath.FromSlash(locations[0])))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Optional dependencies may have failed to install
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the license of %s: %w", key, err)
+		}
+		for i, file := range files {
+			files[i] = locations[0] + "/" + file
+		}
+		licenses = append(licenses, PackageLicense{Package: key, Name: p.Name, Version: p.Version, License: license, Files: files})
+	}
+	slices.SortFunc(licenses, func(a, b PackageLicense) int {
+		return strings.Compare(a.Package, b.Package)
+	})
+	return licenses, nil
+}
+
+// ReadLicense reads the license of the package installed in dir from the license
+// field of its package.json, or detects it from its license files.
+func ReadLicense(dir string) (string, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		upper := strings.ToUpper(entry.Name())
+		if !entry.IsDir() && slices.ContainsFunc(licenseFilePrefixes, func(prefix string) bool { return strings.HasPrefix(upper, prefix) }) {
+			files = append(files, entry.Name())
+		}
+	}
+
+	var manifest struct {
+		License  json.RawMessage   `json:"license"`
+		Licenses []json.RawMessage `json:"licenses"`
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, PACKAGE_JSON)); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return "", nil, fmt.Errorf("error decoding %s: %w", PACKAGE_JSON, err)
+		}
+	}
+	license := licenseField(manifest.License)
+	if license == "" && len(manifest.Licenses) > 0 {
+		// The legacy licenses field lists alternatives
+		alternatives := []string{}
+		for _, entry := range manifest.Licenses {
+			if alternative := licenseField(entry); alternative != "" {
+				alternatives = append(alternatives, alternative)
+			}
+		}
+		license = strings.Join(alternatives, " OR ")
+	}
+
+	if license == "" || strings.HasPrefix(license, SEE_LICENSE_IN) {
+		for _, file := range files {
+			if detected := detectLicense(filepath.Join(dir, file)); detected != "" {
+				return detected, files, nil
+			}
+		}
+	}
+	if license == "" {
+		license = LICENSE_UNKNOWN
+	}
+	return license, files, nil
+}
+
+// licenseField reads a license given as a string or as a legacy {"type": ...} object.
+func licenseField(raw json.RawMessage) string {
+	var license string
+	if json.Unmarshal(raw, &license) == nil {
+		return strings.TrimSpace(license)
+	}
+	var legacy struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &legacy) == nil {
+		return strings.TrimSpace(legacy.Type)
+	}
+	return ""
+}
+
+// detectLicense recognizes common licenses from the text of a license file.
+func detectLicense(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	text := strings.Join(strings.Fields(string(data)), " ")
+	for _, detector := range licenseDetectors {
+		if !slices.ContainsFunc(detector.phrases, func(phrase string) bool { return !strings.Contains(text, phrase) }) {
+			return detector.license
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLicenses ensures licenses are read from package.json or detected from license files
+func TestLicenses(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	install := func(name, manifest string, files map[string]string) {
+		pkgDir := filepath.Join(dir, NODE_MODULE, name)
+		assert.NoError(t, os.MkdirAll(pkgDir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, PACKAGE_JSON), []byte(manifest), 0644))
+		for file, text := range files {
+			assert.NoError(t, os.WriteFile(filepath.Join(pkgDir, file), []byte(text), 0644))
+		}
+	}
+	install("a", `{"name":"a","license":"MIT"}`, map[string]string{"LICENSE": "MIT License"})
+	install("b", `{"name":"b","license":{"type":"ISC"}}`, nil)
+	install("c", `{"name":"c","licenses":[{"type":"MIT"},{"type":"Apache-2.0"}]}`, nil)
+	install("d", `{"name":"d"}`, map[string]string{"LICENSE.md": "Apache License\n  Version 2.0, January 2004"})
+	install("e", `{"name":"e"}`, nil)
+
+	lock := NewLockfile()
+	layout := Layout{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "missing"} {
+		lock.Packages[name+"@1.0.0"] = &LockPackage{Name: name, Version: "1.0.0"}
+		layout[modulePath("", name)] = name + "@1.0.0"
+	}
+	licenses, err := lock.Licenses(layout)
+	assert.NoError(t, err)
+
+	found := map[string]string{}
+	for _, license := range licenses {
+		found[license.Name] = license.License
+	}
+	assert.Equal(t, map[string]string{
+		"a": "MIT",
+		"b": "ISC",
+		"c": "MIT OR Apache-2.0",
+		"d": "Apache-2.0",
+		"e": LICENSE_UNKNOWN,
+	}, found, "packages missing from node_modules are skipped")
+	assert.Equal(t, []string{"node_modules/a/LICENSE"}, licenses[0].Files)
+
+	policy := Config{"license-allow": "MIT, ISC", "license-deny": "GPL-3.0"}.LicensePolicy()
+	assert.True(t, policy.Allows("(MIT OR GPL-3.0)"), "one allowed alternative is enough")
+	assert.False(t, policy.Allows("MIT AND GPL-3.0"), "every license of AND is required")
+	assert.False(t, policy.Allows(LICENSE_UNKNOWN), "licenses outside the allow list")
+	assert.Equal(t, []string{"d@1.0.0", "e@1.0.0"}, packageKeys(policy.Violations(licenses)), "c is available under MIT")
+
+	denyOnly := LicensePolicy{Deny: []string{"gpl-3.0"}}
+	assert.True(t, denyOnly.Allows(LICENSE_UNKNOWN))
+	assert.False(t, denyOnly.Allows("GPL-3.0 WITH Classpath-exception-2.0"), "deny ignores case and exceptions")
+}
+
+// packageKeys returns the packages of licenses.
+func packageKeys(licenses []PackageLicense) []string {
+	keys := []string{}
+	for _, license := range licenses {
+		keys = append(keys, license.Package)
+	}
+	return keys
+}
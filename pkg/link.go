@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// CreateLinks creates the relative symlinks of a node_modules layout, replacing
+// whatever previously lived at their location within the transaction.
+func CreateLinks(links Links, tx *Transaction) error {
+	cwd := GetCwd()
+	for _, location := range slices.Sorted(maps.Keys(links)) {
+		linkPath := filepath.Join(cwd, filepath.FromSlash(location))
+		targetPath := filepath.FromSlash(links[location])
+		if !filepath.IsAbs(targetPath) {
+			targetPath = filepath.Join(cwd, targetPath)
+		}
+		target, err := filepath.Rel(filepath.Dir(linkPath), targetPath)
+		if err != nil {
+			return fmt.Errorf("failed to link %s: %w", location, err)
+		}
+		if existing, err := os.Readlink(linkPath); err == nil && existing == target {
+			continue
+		}
+
+		if err := tx.Replace(linkPath); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", location, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", location, err)
+		}
+		if err := os.Symlink(target, linkPath); err != nil {
+			return fmt.Errorf("failed to link %s: %w", location, err)
+		}
+	}
+	return nil
+}
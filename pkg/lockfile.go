@@ -0,0 +1,310 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	LOCK_FILE        = "gopm-lock.json"
+	LOCKFILE_VERSION = 1
+	ROOT_IMPORTER    = "."
+	LINK_PROTOCOL    = "link:"
+)
+
+// Dependency types that can be left out of an install
+const (
+	OMIT_DEV      = "dev"
+	OMIT_OPTIONAL = "optional"
+	OMIT_PEER     = "peer"
+)
+
+// Lockfile is a representation of a gopm-lock.json file. It records the
+// resolved dependency graph independently of the node_modules layout.
+type Lockfile struct {
+	LockfileVersion int                      `json:"lockfileVersion"`
+	Importers       map[string]*LockImporter `json:"importers"`
+	Packages        map[string]*LockPackage  `json:"packages"`
+	// Overrides are the overrides and resolutions the graph was resolved with
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// LockImporter is a representation of the direct dependencies of a project.
+type LockImporter struct {
+	Dependencies         map[string]LockDependency `json:"dependencies,omitempty"`
+	DevDependencies      map[string]LockDependency `json:"devDependencies,omitempty"`
+	OptionalDependencies map[string]LockDependency `json:"optionalDependencies,omitempty"`
+	PeerDependencies     map[string]LockDependency `json:"peerDependencies,omitempty"`
+}
+
+// LockDependency is an edge of the dependency graph: the range requested
+// by the dependent and the key of the package it resolved to.
+type LockDependency struct {
+	Specifier string `json:"specifier"`
+	Package   string `json:"package"`
+}
+
+// LockPackage is a representation of a resolved package.
+type LockPackage struct {
+	Name                 string                    `json:"name"`
+	Version              string                    `json:"version"`
+	Resolved             string                    `json:"resolved"`
+	Integrity            string                    `json:"integrity,omitempty"`
+	Dependencies         map[string]LockDependency `json:"dependencies,omitempty"`
+	OptionalDependencies map[string]LockDependency `json:"optionalDependencies,omitempty"`
+	Engines              Engines                   `json:"engines,omitempty"`
+	Dev                  bool                      `json:"dev,omitempty"`
+	Optional             bool                      `json:"optional,omitempty"`
+}
+
+// PackageKey returns the key of a package in the lockfile.
+func PackageKey(name, version string) string {
+	return name + "@" + version
+}
+
+// NewLockfile creates an empty lockfile.
+func NewLockfile() *Lockfile {
+	return &Lockfile{
+		LockfileVersion: LOCKFILE_VERSION,
+		Importers:       map[string]*LockImporter{ROOT_IMPORTER: {}},
+		Packages:        map[string]*LockPackage{},
+	}
+}
+
+// ReadLockfile reads the gopm-lock.json file. It returns nil when the file does not exist.
+func ReadLockfile() (*Lockfile, error) {
+	return readLockfile(filepath.Join(GetCwd(), LOCK_FILE))
+}
+
+// readLockfile reads a lockfile. It returns nil when the file does not exist.
+func readLockfile(path string) (*Lockfile, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lock Lockfile
+	if err := json.NewDecoder(file).Decode(&lock); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", LOCK_FILE, err)
+	}
+	if lock.LockfileVersion != LOCKFILE_VERSION {
+		return nil, fmt.Errorf("unsupported %s version %d", LOCK_FILE, lock.LockfileVersion)
+	}
+	return &lock, nil
+}
+
+// Write writes the lockfile to gopm-lock.json.
+func (l *Lockfile) Write() error {
+	lockPath := filepath.Join(GetCwd(), LOCK_FILE)
+	tempFilePath := lockPath + ".tmp"
+	tempFile, err := os.OpenFile(tempFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening temp file: %w", err)
+	}
+	defer tempFile.Close()
+
+	// Ensure cleanup if an error occurs
+	defer os.Remove(tempFilePath)
+
+	encoder := json.NewEncoder(tempFile)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", INDENT)
+	if err := encoder.Encode(l); err != nil {
+		return fmt.Errorf("error encoding %s: %w", LOCK_FILE, err)
+	}
+
+	// Replace original file (atomic operation to prevent corruption)
+	if err := os.Rename(tempFilePath, lockPath); err != nil {
+		return fmt.Errorf("error replacing file: %w", err)
+	}
+	return nil
+}
+
+// Edges returns the direct dependencies of an importer, sorted by name.
+func (i *LockImporter) Edges() []NamedDependency {
+	return sortedEdges(i.Dependencies, i.DevDependencies, i.OptionalDependencies, i.PeerDependencies)
+}
+
+// Edge returns the direct dependency of an importer with a given name.
+func (i *LockImporter) Edge(name string) (LockDependency, bool) {
+	for _, m := range []map[string]LockDependency{i.Dependencies, i.DevDependencies, i.OptionalDependencies, i.PeerDependencies} {
+		if dep, ok := m[name]; ok {
+			return dep, true
+		}
+	}
+	return LockDependency{}, false
+}
+
+// Edges returns the dependencies of a package, sorted by name.
+func (p *LockPackage) Edges() []NamedDependency {
+	return sortedEdges(p.Dependencies, p.OptionalDependencies)
+}
+
+// NamedDependency is a dependency edge along with the name it is required as.
+type NamedDependency struct {
+	Name string
+	LockDependency
+}
+
+// sortedEdges merges dependency maps into a slice sorted by name.
+func sortedEdges(maps ...map[string]LockDependency) []NamedDependency {
+	edges := []NamedDependency{}
+	for _, m := range maps {
+		for name, dep := range m {
+			edges = append(edges, NamedDependency{name, dep})
+		}
+	}
+	slices.SortFunc(edges, func(a, b NamedDependency) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return edges
+}
+
+// Match returns the sorted keys of the packages matching a query such as
+// lodash, lodash@4.17.21 or lodash@^4.
+func (l *Lockfile) Match(query string) ([]string, error) {
+	spec, err := ParseSpec(query)
+	if err != nil {
+		return nil, err
+	}
+	name, rng := spec.Name, ""
+	if spec.Type == SPEC_REGISTRY && spec.Raw != LATEST_TAG {
+		name, rng = spec.Package, spec.Range
+	}
+	keys := []string{}
+	for key, p := range l.Packages {
+		if p.Name == name && (rng == "" || p.Version == rng || Satisfies(p.Version, rng)) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No package matching %s in %s", query, LOCK_FILE)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// LockedVersions returns every locked version of each package name.
+func (l *Lockfile) LockedVersions() map[string][]string {
+	versions := map[string][]string{}
+	if l == nil {
+		return versions
+	}
+	for _, p := range l.Packages {
+		if _, ok := p.linkTarget(); !ok {
+			versions[p.Name] = append(versions[p.Name], p.Version)
+		}
+	}
+	return versions
+}
+
+// LockedExternal returns the packages resolved from outside the registry,
+// keyed by the name and specifier they were required with.
+func (l *Lockfile) LockedExternal() map[string]*LockPackage {
+	external := map[string]*LockPackage{}
+	if l == nil {
+		return external
+	}
+	edges := []NamedDependency{}
+	for _, importer := range l.Importers {
+		edges = append(edges, importer.Edges()...)
+	}
+	for _, p := range l.Packages {
+		edges = append(edges, p.Edges()...)
+	}
+	for _, edge := range edges {
+		p, ok := l.Packages[edge.Package]
+		if ok && edge.Package != PackageKey(p.Name, p.Version) {
+			external[PackageKey(edge.Name, edge.Specifier)] = p
+		}
+	}
+	return external
+}
+
+// specifiers returns the specifiers of dependency edges by name.
+func (p *LockPackage) specifiers(edges map[string]LockDependency) map[string]string {
+	specifiers := map[string]string{}
+	for name, dep := range edges {
+		specifiers[name] = dep.Specifier
+	}
+	return specifiers
+}
+
+// Omit returns a copy of the lockfile without the dependency types in omit and
+// the packages only they require, leaving the lockfile itself intact. Packages
+// no longer reachable from any importer are left out as well.
+func (l *Lockfile) Omit(omit []string) *Lockfile {
+	withOptional := !slices.Contains(omit, OMIT_OPTIONAL)
+	out := &Lockfile{LockfileVersion: l.LockfileVersion, Importers: map[string]*LockImporter{}, Packages: map[string]*LockPackage{}, Overrides: l.Overrides}
+	roots := []LockDependency{}
+	for dir, importer := range l.Importers {
+		kept := &LockImporter{Dependencies: importer.Dependencies}
+		if !slices.Contains(omit, OMIT_DEV) {
+			kept.DevDependencies = importer.DevDependencies
+		}
+		if withOptional {
+			kept.OptionalDependencies = importer.OptionalDependencies
+		}
+		if !slices.Contains(omit, OMIT_PEER) {
+			kept.PeerDependencies = importer.PeerDependencies
+		}
+		out.Importers[dir] = kept
+		for _, edge := range kept.Edges() {
+			roots = append(roots, edge.LockDependency)
+		}
+	}
+	for key := range l.reachable(roots, withOptional) {
+		p := *l.Packages[key]
+		if !withOptional {
+			p.OptionalDependencies = nil
+		}
+		out.Packages[key] = &p
+	}
+	return out
+}
+
+// Without returns a copy of the lockfile without the packages of keys and those
+// only they require, leaving the lockfile itself intact.
+func (l *Lockfile) Without(keys []string) *Lockfile {
+	out := &Lockfile{LockfileVersion: l.LockfileVersion, Importers: l.Importers, Packages: maps.Clone(l.Packages), Overrides: l.Overrides}
+	for _, key := range keys {
+		delete(out.Packages, key)
+	}
+	roots := []LockDependency{}
+	for _, importer := range l.Importers {
+		for _, edge := range importer.Edges() {
+			roots = append(roots, edge.LockDependency)
+		}
+	}
+	reachable := out.reachable(roots, true)
+	maps.DeleteFunc(out.Packages, func(key string, _ *LockPackage) bool { return !reachable[key] })
+	return out
+}
+
+// ParseOmit validates the dependency types to omit, given comma separated or repeated.
+func ParseOmit(values []string) ([]string, error) {
+	omit := []string{}
+	for _, value := range values {
+		for _, kind := range strings.Split(value, ",") {
+			switch kind = strings.TrimSpace(kind); kind {
+			case "":
+			case OMIT_DEV, OMIT_OPTIONAL, OMIT_PEER:
+				if !slices.Contains(omit, kind) {
+					omit = append(omit, kind)
+				}
+			default:
+				return nil, fmt.Errorf("cannot omit %q, expected dev, optional or peer", kind)
+			}
+		}
+	}
+	return omit, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// linkCount returns the number of hardlinks to a file.
+func linkCount(path string, info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// linkCount returns the number of hardlinks to a file.
+func linkCount(path string, info fs.FileInfo) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	var data windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(f.Fd()), &data); err != nil {
+		return 0, false
+	}
+	return uint64(data.NumberOfLinks), true
+}
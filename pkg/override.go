@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Override forces the specifier of a dependency, optionally only under some
+// ancestors. It comes from the npm overrides or the Yarn resolutions field.
+type Override struct {
+	// Parents are the ancestors, outermost first, the override applies under
+	Parents []OverrideSelector
+	Target  OverrideSelector
+	// Specifier replaces the requested one, $name refers to a root dependency
+	Specifier string
+	// Source is the override as written, such as bar>baz or **/bar/baz
+	Source string
+}
+
+// OverrideSelector matches packages by name and optionally by version range.
+type OverrideSelector struct {
+	Name  string
+	Range string
+}
+
+// overrideNode is an ancestor of a dependency being resolved.
+type overrideNode struct {
+	name    string
+	version string
+}
+
+// ParseOverrides reads the overrides and resolutions fields of the root package.json.
+// Overrides come first so that they win over resolutions of the same specificity.
+func (p *PackageJSON) ParseOverrides() ([]Override, error) {
+	overrides := []Override{}
+	if len(p.Overrides) > 0 {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(p.Overrides, &raw); err != nil {
+			return nil, fmt.Errorf("overrides should be an object: %w", err)
+		}
+		if err := parseOverrides(raw, nil, "", &overrides); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(p.Resolutions)) {
+		segments := resolutionSegments(key)
+		if len(segments) == 0 {
+			return nil, fmt.Errorf("invalid resolution %q", key)
+		}
+		override := Override{Target: parseSelector(segments[len(segments)-1]), Specifier: p.Resolutions[key], Source: key}
+		for _, segment := range segments[:len(segments)-1] {
+			override.Parents = append(override.Parents, parseSelector(segment))
+		}
+		overrides = append(overrides, override)
+	}
+
+	// Resolve references to the specifiers of root dependencies
+	for i, override := range overrides {
+		name, ok := strings.CutPrefix(override.Specifier, "$")
+		if !ok {
+			continue
+		}
+		spec, ok := p.declared(name)
+		if !ok {
+			return nil, fmt.Errorf("override %s refers to %s which is not a dependency of package.json", override.Source, override.Specifier)
+		}
+		overrides[i].Specifier = spec
+	}
+	return overrides, nil
+}
+
+// resolutionSegments splits a Yarn resolution pattern such as a/b, **/b or
+// @scope/a/**/b into package selectors.
+func resolutionSegments(pattern string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(pattern, "/") {
+		switch {
+		case segment == "**" || segment == "":
+		case len(segments) > 0 && strings.HasPrefix(segments[len(segments)-1], "@") && !strings.Contains(segments[len(segments)-1], "/"):
+			segments[len(segments)-1] += "/" + segment
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// overriddenNames returns the names of the packages targeted by the recorded overrides.
+func (l *Lockfile) overriddenNames() map[string]bool {
+	names := map[string]bool{}
+	for source := range l.Overrides {
+		if index := strings.LastIndex(source, ">"); index >= 0 {
+			source = source[index+1:]
+		}
+		if segments := resolutionSegments(source); len(segments) > 0 {
+			names[parseSelector(segments[len(segments)-1]).Name] = true
+		}
+	}
+	return names
+}
+
+// parseOverrides flattens nested npm overrides, where the . key overrides the parent itself.
+func parseOverrides(raw map[string]json.RawMessage, parents []OverrideSelector, source string, out *[]Override) error {
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		selector := parseSelector(key)
+		var specifier string
+		if err := json.Unmarshal(raw[key], &specifier); err == nil {
+			if key == "." {
+				continue
+			}
+			*out = append(*out, Override{Parents: slices.Clone(parents), Target: selector, Specifier: specifier, Source: source + key})
+			continue
+		}
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(raw[key], &nested); err != nil {
+			return fmt.Errorf("override %s should be a specifier or an object: %w", source+key, err)
+		}
+		if self, ok := nested["."]; ok {
+			if err := json.Unmarshal(self, &specifier); err != nil {
+				return fmt.Errorf("override %s should be a specifier: %w", source+key+">.", err)
+			}
+			*out = append(*out, Override{Parents: slices.Clone(parents), Target: selector, Specifier: specifier, Source: source + key})
+		}
+		if err := parseOverrides(nested, append(slices.Clone(parents), selector), source+key+">", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSelector parses name or name@range, the name of scoped packages starts with @.
+func parseSelector(key string) OverrideSelector {
+	if index := strings.LastIndex(key, "@"); index > 0 {
+		return OverrideSelector{Name: key[:index], Range: key[index+1:]}
+	}
+	return OverrideSelector{Name: key}
+}
+
+// declared returns the specifier of a dependency declared in any section of package.json.
+func (p *PackageJSON) declared(name string) (string, bool) {
+	for _, section := range []map[string]string{p.Dependencies, p.DevDependencies, p.OptionalDependencies, p.PeerDependencies} {
+		if spec, ok := section[name]; ok {
+			return spec, true
+		}
+	}
+	return "", false
+}
+
+// matches reports whether a node matches the selector.
+func (s OverrideSelector) matches(name, version string) bool {
+	return s.Name == name && (s.Range == "" || version == s.Range || Satisfies(version, s.Range))
+}
+
+// appliesUnder reports whether the parents of an override are a subsequence of the ancestors.
+func (o *Override) appliesUnder(ancestors []overrideNode) bool {
+	i := 0
+	for _, ancestor := range ancestors {
+		if i < len(o.Parents) && o.Parents[i].matches(ancestor.name, ancestor.version) {
+			i++
+		}
+	}
+	return i == len(o.Parents)
+}
+
+// override returns the specifier a request is resolved with, applying the most specific
+// matching override. Direct dependencies of the projects are never overridden.
+func (r *Resolver) override(ctx context.Context, req resolveRequest) string {
+	if req.parents == nil {
+		return req.spec
+	}
+	best := -1
+	for i := range r.overrides {
+		o := &r.overrides[i]
+		if o.Target.Name != req.name || !o.appliesUnder(req.parents) {
+			continue
+		}
+		if best >= 0 && len(o.Parents) <= len(r.overrides[best].Parents) {
+			continue
+		}
+		// A range on the target is checked against the version the request would resolve to
+		if o.Target.Range != "" && !o.Target.matches(req.name, r.originalVersion(ctx, req)) {
+			continue
+		}
+		best = i
+	}
+	if best < 0 {
+		return req.spec
+	}
+	r.usedOverrides[best] = true
+	return r.overrides[best].Specifier
+}
+
+// originalVersion returns the version a registry request resolves to without overrides.
+func (r *Resolver) originalVersion(ctx context.Context, req resolveRequest) string {
+	name, ok := registryName(req.name, req.spec)
+	if !ok {
+		return ""
+	}
+	spec, err := ParseDependency(req.name, req.spec)
+	if err != nil {
+		return ""
+	}
+	body, err := r.Packument(ctx, name)
+	if err != nil {
+		return ""
+	}
+	return pickVersion(body, spec.Range, r.locked[name])
+}
+
+// recordOverrides records the overrides in the lockfile and warns about those matching nothing.
+func (r *Resolver) recordOverrides(lock *Lockfile) {
+	if len(r.overrides) == 0 {
+		return
+	}
+	lock.Overrides = map[string]string{}
+	for i, o := range r.overrides {
+		lock.Overrides[o.Source] = o.Specifier
+		if !r.usedOverrides[i] {
+			logrus.Warnf("Override %s does not match any dependency", o.Source)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolveOverrides ensures overrides and resolutions force transitive dependencies
+func TestResolveOverrides(t *testing.T) {
+	registry := fakeRegistry{
+		"a":        {"1.0.0": {"minimist": "^1.0.0", "c": "^1.0.0"}},
+		"b":        {"1.0.0": {"c": "^1.0.0"}},
+		"c":        {"1.0.0": nil, "1.1.0": nil, "2.0.0": nil},
+		"minimist": {"1.0.0": nil, "1.2.6": nil, "1.2.8": nil},
+	}
+	p := &PackageJSON{
+		Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"},
+		Overrides: json.RawMessage(`{
+			"minimist": "1.2.6",
+			"a": {"c": "2.0.0"},
+			"unused": "1.0.0"
+		}`),
+		Resolutions: map[string]string{"**/b/c": "1.0.0"},
+	}
+	lock := registry.resolve(t, p)
+
+	assert.Equal(t, "minimist@1.2.6", lock.Packages["a@1.0.0"].Dependencies["minimist"].Package)
+	assert.Equal(t, "^1.0.0", lock.Packages["a@1.0.0"].Dependencies["minimist"].Specifier, "the requested specifier should be kept")
+	assert.Equal(t, "c@2.0.0", lock.Packages["a@1.0.0"].Dependencies["c"].Package, "nested overrides apply under their parent")
+	assert.Equal(t, "c@1.0.0", lock.Packages["b@1.0.0"].Dependencies["c"].Package, "resolutions apply under their parent")
+	assert.Equal(t, map[string]string{"minimist": "1.2.6", "a>c": "2.0.0", "unused": "1.0.0", "**/b/c": "1.0.0"}, lock.Overrides)
+
+	// Overridden packages are not deduped back
+	deduped := lock.Dedupe()
+	assert.Equal(t, "c@1.0.0", deduped.Packages["b@1.0.0"].Dependencies["c"].Package)
+}
+
+// TestResolveOverridesSharedPackage ensures nested overrides see the shallowest path to a shared package
+func TestResolveOverridesSharedPackage(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"b": "^1.0.0"}},
+		"b": {"1.0.0": {"c": "^1.0.0"}},
+		"c": {"1.0.0": nil, "1.1.0": nil},
+	}
+	nested := json.RawMessage(`{"a": {"c": "1.0.0"}}`)
+
+	lock := registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}, Overrides: nested})
+	assert.Equal(t, "c@1.0.0", lock.Packages["b@1.0.0"].Dependencies["c"].Package, "b is only reached through a")
+
+	// b is reached from the project before a, its dependencies are not under a
+	lock = registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, Overrides: nested})
+	assert.Equal(t, "c@1.1.0", lock.Packages["b@1.0.0"].Dependencies["c"].Package)
+	lock = registry.resolve(t, &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, Overrides: json.RawMessage(`{"b": {"c": "1.0.0"}}`)})
+	assert.Equal(t, "c@1.0.0", lock.Packages["b@1.0.0"].Dependencies["c"].Package, "a path through the shared package applies")
+}
+
+// TestParseOverrides ensures references to root dependencies and range selectors are parsed
+func TestParseOverrides(t *testing.T) {
+	p := &PackageJSON{
+		Dependencies: map[string]string{"react": "^18.0.0"},
+		Overrides:    json.RawMessage(`{"@s/x@1": {".": "1.2.0", "react": "$react"}}`),
+	}
+	overrides, err := p.ParseOverrides()
+	assert.NoError(t, err)
+	assert.Equal(t, []Override{
+		{Target: OverrideSelector{Name: "@s/x", Range: "1"}, Specifier: "1.2.0", Source: "@s/x@1"},
+		{Parents: []OverrideSelector{{Name: "@s/x", Range: "1"}}, Target: OverrideSelector{Name: "react"}, Specifier: "^18.0.0", Source: "@s/x@1>react"},
+	}, overrides)
+
+	p.Overrides = json.RawMessage(`{"react": "$vue"}`)
+	_, err = p.ParseOverrides()
+	assert.Error(t, err, "references should point to root dependencies")
+}
@@ -8,7 +8,6 @@ import (
 	"maps"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -23,25 +22,66 @@ const (
 	PACKAGE_JSON             = "package.json"
 	INDENT                   = "  "
 	MAX_CONCURRENT_DOWNLOADS = 20
+	LATEST_TAG               = "latest"
+	// INSTALLED_MARKER records in an installed package the store key it was installed from
+	INSTALLED_MARKER = ".gopm-installed"
+)
+
+// Sections of package.json declaring dependencies
+const (
+	DEPENDENCIES          = "dependencies"
+	DEV_DEPENDENCIES      = "devDependencies"
+	OPTIONAL_DEPENDENCIES = "optionalDependencies"
+	PEER_DEPENDENCIES     = "peerDependencies"
 )
 
 // PackageJSON is a representation of a package.json file.
 type PackageJSON struct {
-	Name            string            `json:"name"`
-	Version         string            `json:"version"`
-	Description     string            `json:"description"`
-	Main            string            `json:"main"`
-	Scripts         map[string]string `json:"scripts"`
-	Dependencies    map[string]string `json:"dependencies"`
-	DevDependencies map[string]string `json:"devDependencies"`
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Description          string            `json:"description"`
+	Main                 string            `json:"main"`
+	Scripts              map[string]string `json:"scripts"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies,omitempty"`
+	PeerDependencies     map[string]string `json:"peerDependencies,omitempty"`
+	Overrides            json.RawMessage   `json:"overrides,omitempty"`
+	Resolutions          map[string]string `json:"resolutions,omitempty"`
+	PatchedDependencies  map[string]string `json:"patchedDependencies,omitempty"`
+	Workspaces           *Workspaces       `json:"workspaces,omitempty"`
+	Bin                  json.RawMessage   `json:"bin,omitempty"`
+	Engines              Engines           `json:"engines,omitempty"`
+	PackageManager       string            `json:"packageManager,omitempty"`
 }
 
 // BodyRegistery is a representation of a response from the npm registry.
 type BodyRegistery struct {
-	Name     string `json:"name"`
-	DistTags struct {
-		Latest string `json:"latest"`
-	} `json:"dist-tags"`
+	Name     string              `json:"name"`
+	DistTags map[string]string   `json:"dist-tags"`
+	Versions map[string]Manifest `json:"versions"`
+	// Time is the publish time of each version
+	Time map[string]string `json:"time"`
+}
+
+// Manifest is a representation of a single version of a package in the npm registry.
+type Manifest struct {
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	Engines              Engines           `json:"engines,omitempty"`
+	Dist                 Dist              `json:"dist"`
+}
+
+// Dist is a representation of the distribution details of a package version.
+type Dist struct {
+	Tarball      string        `json:"tarball"`
+	Shasum       string        `json:"shasum"`
+	Integrity    string        `json:"integrity"`
+	Signatures   []Signature   `json:"signatures,omitempty"`
+	Attestations *Attestations `json:"attestations,omitempty"`
 }
 
 type Dependency struct {
@@ -49,16 +89,14 @@ type Dependency struct {
 	Version string `json:"version"`
 }
 
-// Tarball returns the tarball URL for a given package.
-func Tarball(dependency, version string) string {
-	if strings.HasPrefix(dependency, "@") {
-		var module = strings.Split(dependency, "/")
-		if err := NewDirectory(module[0]); err != nil {
-			logrus.Errorf("Failed to create directory for %s: %v", dependency, err)
-		}
-		return fmt.Sprintf("%s%s/-/%s-%s.tgz", NPM_REGISTRY, dependency, module[1], version)
+// Tarball returns the tarball URL of a package version in a registry.
+func Tarball(registry, dependency, version string) string {
+	// Scoped packages are published as @scope/name/-/name-version.tgz
+	base := dependency
+	if _, name, ok := strings.Cut(dependency, "/"); ok {
+		base = name
 	}
-	return fmt.Sprintf("%s%s/-/%s-%s.tgz", NPM_REGISTRY, dependency, dependency, version)
+	return fmt.Sprintf("%s%s/-/%s-%s.tgz", registry, dependency, base, version)
 }
 
 // AddDependency adds dependency to the package.json file.
@@ -83,54 +121,94 @@ func (p *PackageJSON) AddDevDependency(dependencies map[string]string) {
 	maps.Copy(p.DevDependencies, dependencies)
 }
 
-// DownloadPackage downloads a package from the npm registry.
-func (b *BodyRegistery) DownloadPackage(dependency, version string) bool {
-	tarball := Tarball(dependency, version)
+// section returns the dependencies of a section of package.json, creating them if needed.
+func (p *PackageJSON) section(name string) *map[string]string {
+	var section *map[string]string
+	switch name {
+	case DEV_DEPENDENCIES:
+		section = &p.DevDependencies
+	case OPTIONAL_DEPENDENCIES:
+		section = &p.OptionalDependencies
+	case PEER_DEPENDENCIES:
+		section = &p.PeerDependencies
+	default:
+		section = &p.Dependencies
+	}
+	if *section == nil {
+		*section = make(map[string]string)
+	}
+	return section
+}
 
+// SaveDependency saves a dependency in a section of package.json,
+// removing it from the other sections it was declared in.
+func (p *PackageJSON) SaveDependency(section, name, spec string) {
+	for _, other := range []string{DEPENDENCIES, DEV_DEPENDENCIES, OPTIONAL_DEPENDENCIES, PEER_DEPENDENCIES} {
+		if other == section {
+			continue
+		}
+		if dependencies := p.section(other); len(*dependencies) > 0 {
+			delete(*dependencies, name)
+		}
+	}
+	(*p.section(section))[name] = spec
+}
+
+// DownloadTarball downloads a package tarball to filePath, verifies its integrity
+// and returns the integrity computed from the content.
+func DownloadTarball(ctx context.Context, dependency, tarball, integrity, filePath string) (string, error) {
 	// Set timeout for HTTP request (e.g., 20 seconds)
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
 	// Create HTTP request
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tarball, nil)
 	if err != nil {
-		logrus.Errorf("Failed to create request for %s: %v", dependency, err)
-		return false
+		return "", fmt.Errorf("Failed to create request for %s: %w", dependency, err)
 	}
 
 	// Send HTTP request
 	client, err := http.DefaultClient.Do(req)
 	if err != nil {
-		logrus.Errorf("Failed to download %s: %v", dependency, err)
-		return false
+		return "", failure(FAILURE_NETWORK, "Failed to download %s: %w", dependency, err)
 	}
 	defer client.Body.Close()
+	if client.StatusCode == http.StatusNotFound {
+		return "", failure(FAILURE_NOT_FOUND, "Failed to download %s: %s", dependency, client.Status)
+	}
+	if client.StatusCode != http.StatusOK {
+		return "", failure(FAILURE_NETWORK, "Failed to download %s: %s", dependency, client.Status)
+	}
 
-	// Get current working directory
-	cwd := GetCwd()
-	fileName := fmt.Sprintf("%s.tgz", dependency)
-	filePath := filepath.Join(cwd, NODE_MODULE, fileName)
-
-	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("Failed to create directory for %s: %w", dependency, err)
+	}
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		logrus.Errorf("Failed to create file %s", filePath)
-		return false
+		return "", fmt.Errorf("Failed to create file %s: %w", filePath, err)
 	}
 	defer f.Close()
 
+	verifier, err := NewIntegrityVerifier(integrity)
+	if err != nil {
+		return "", failure(FAILURE_INTEGRITY, "Invalid integrity for %s: %w", dependency, err)
+	}
+	writer := io.MultiWriter(f, verifier)
 	if client.ContentLength > 0 {
 		// Create progress bar
 		bar := progressbar.DefaultBytes(client.ContentLength, fmt.Sprintf("Downloading %s...", dependency))
-		// Copy the package to the node_modules folder
-		if _, err := io.Copy(io.MultiWriter(bar, f), client.Body); err != nil {
-			logrus.Errorf("Failed to copy %s: %v", dependency, err)
-			return false
-		}
+		writer = io.MultiWriter(bar, writer)
+	}
+	// Copy the package to the node_modules folder
+	if _, err := io.Copy(writer, client.Body); err != nil {
+		return "", failure(FAILURE_NETWORK, "Failed to copy %s: %w", dependency, err)
+	}
+	if err := verifier.Verify(); err != nil {
+		return "", failure(FAILURE_INTEGRITY, "Failed to verify %s: %w", dependency, err)
 	}
 
-	// logrus.Infof("Successfully downloaded %s\n\n", dependency)
 	fmt.Printf("✅ Successfully downloaded %s\n\n", dependency)
-	return true
+	return verifier.Integrity(), nil
 }
 
 // NewDirectory creates a new directory.
@@ -156,54 +234,112 @@ func GetCwd() string {
 	return cwd
 }
 
-// UnzipDependency unzips a dependency.
-func UnzipDependency(filePath string) error {
-	compressedFile := fmt.Sprintf("%s.tgz", filePath)
+// InstallPackage imports a resolved package into the store, downloading it only
+// when the store does not know it yet, and stages a copy from the store for every
+// node_modules location in the transaction. Locations already containing the same
+// tarball or commit, as recorded by their marker, are left untouched unless force is
+// set. It returns the staged directories.
+func InstallPackage(ctx context.Context, tx *Transaction, store *Store, p *LockPackage, locations []string, force bool) ([]string, error) {
+	cwd := GetCwd()
+	pending := []string{}
+	for _, location := range locations {
+		dependencyPath := filepath.Join(cwd, filepath.FromSlash(location))
+		if info, err := os.Lstat(dependencyPath); !force && err == nil && info.IsDir() && !strings.HasPrefix(p.Resolved, FILE_PROTOCOL) && installedKey(dependencyPath) == p.storeKey() {
+			continue
+		}
+		pending = append(pending, location)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
 
-	// Ensure the directory exists
-	if err := os.MkdirAll(filePath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", filePath, err)
+	index, ok := store.Index(p.storeKey())
+	if !ok {
+		var err error
+		if index, err = store.fetchIndex(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+
+	staged := []string{}
+	for _, location := range pending {
+		dir, err := tx.Stage(location)
+		if err != nil {
+			tx.Unstage(pending...)
+			return nil, err
+		}
+		if err := store.Link(index, dir); err != nil {
+			tx.Unstage(pending...)
+			return nil, fmt.Errorf("failed to install %s: %w", p.Name, err)
+		}
+		// Git and tarball dependencies keep their version when their content changes
+		if err := os.WriteFile(filepath.Join(dir, INSTALLED_MARKER), []byte(p.storeKey()), 0644); err != nil {
+			tx.Unstage(pending...)
+			return nil, fmt.Errorf("failed to install %s: %w", p.Name, err)
+		}
+		staged = append(staged, dir)
 	}
+	return staged, nil
+}
 
-	// Execute tar command safely
-	cmd := exec.Command("tar", "-xzf", compressedFile, "--strip-components=1", "-C", filePath)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to unzip %s: %w", compressedFile, err)
+// installedKey returns the store key of the package installed in dir or an empty string.
+func installedKey(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, INSTALLED_MARKER))
+	if err != nil {
+		return ""
 	}
+	return string(data)
+}
 
-	// Remove compressed file
-	if err := os.Remove(compressedFile); err != nil {
-		logrus.Errorf("failed to remove %s: %v", compressedFile, err)
+// InstalledVersion returns the version of the package installed in dir or an empty string.
+func InstalledVersion(dir string) string {
+	var p PackageJSON
+	data, err := os.ReadFile(filepath.Join(dir, PACKAGE_JSON))
+	if err != nil || json.Unmarshal(data, &p) != nil {
+		return ""
 	}
+	return p.Version
+}
 
-	return nil
+// GetDependencyLatest gets the latest version of a dependency from a registry.
+func (body *BodyRegistery) GetDependencyLatest(ctx context.Context, registry, dependency string) (string, error) {
+	if err := body.GetPackument(ctx, registry, dependency); err != nil {
+		return "", err
+	}
+	return body.DistTags[LATEST_TAG], nil
 }
 
-// GetDependencyLatest gets the latest version of a dependency from the npm registry.
-func (body *BodyRegistery) GetDependencyLatest(dependency string) (string, error) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+// GetPackument gets the package document (all versions) of a dependency from a registry.
+func (body *BodyRegistery) GetPackument(ctx context.Context, registry, dependency string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	packageURL := fmt.Sprintf("%s%s", NPM_REGISTRY, dependency)
+	packageURL := fmt.Sprintf("%s%s", registry, dependency)
 
-	// Fetch the package information from the npm registry
+	// Fetch the package information from the registry
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, packageURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("Failed to initialize request for %s", dependency)
+		return fmt.Errorf("Failed to initialize request for %s", dependency)
 	}
 
 	// Send HTTP request
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("Failed to fetch %s. Please check network config or try again", dependency)
+		return failure(FAILURE_NETWORK, "Failed to fetch %s. Please check network config or try again: %w", dependency, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return failure(FAILURE_NOT_FOUND, "Package %s not found in the registry", dependency)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return failure(FAILURE_NETWORK, "Failed to fetch %s: %s", dependency, resp.Status)
+	}
 
 	// Decode the response body
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-		return "", fmt.Errorf("Failed to decode dependency for %s", dependency)
+		return failure(FAILURE_NETWORK, "Failed to decode dependency for %s", dependency)
 	}
-	return body.DistTags.Latest, nil
+	return nil
 }
 
 // CreateNodeModulesFolder creates a node_modules folder
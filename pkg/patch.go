@@ -0,0 +1,213 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	PATCHES_DIR = "patches"
+	PATCH_STATE = ".gopm-patch.json"
+)
+
+// PatchState records the package a folder created by gopm patch is a copy of.
+type PatchState struct {
+	Package string `json:"package"`
+}
+
+// PatchFile returns the path, relative to the project, of the patch of a package.
+func PatchFile(p *LockPackage) string {
+	return path.Join(PATCHES_DIR, strings.ReplaceAll(p.Name, "/", "__")+"@"+p.Version+".patch")
+}
+
+// ExtractPackage copies the pristine files of a package from the store into dir.
+func (s *Store) ExtractPackage(ctx context.Context, p *LockPackage, dir string) error {
+	index, ok := s.Index(p.storeKey())
+	if !ok {
+		var err error
+		if index, err = s.fetchIndex(ctx, p); err != nil {
+			return err
+		}
+	}
+	// Files are copied so that editing them leaves the store untouched
+	copier := &Store{Dir: s.Dir, ImportMethod: IMPORT_COPY}
+	return copier.Link(index, dir)
+}
+
+// DiffPackage returns the unified diff between the pristine files of a package
+// and an edited copy of it in dir.
+func (s *Store) DiffPackage(p *LockPackage, dir string) (string, error) {
+	index, ok := s.Index(p.storeKey())
+	if !ok {
+		return "", fmt.Errorf("%s is missing from the store. Run 'gopm install' first", PackageKey(p.Name, p.Version))
+	}
+
+	edited, executable := map[string]string{}, map[string]bool{}
+	err := walkPackage(dir, func(name, path string, info fs.FileInfo) error {
+		if name == PATCH_STATE {
+			return nil
+		}
+		edited[name] = path
+		executable[name] = info.Mode()&0111 != 0
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var diff strings.Builder
+	names := slices.Sorted(maps.Keys(edited))
+	for name := range index.Files {
+		if _, ok := edited[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		oldPath, newPath, oldText, newText := name, name, "", ""
+		var oldMode, newMode uint32
+		if file, ok := index.Files[name]; ok {
+			if oldText, err = readText(s.filePath(file.Digest, file.Mode), name); err != nil {
+				return "", err
+			}
+			oldMode = file.Mode
+		} else {
+			oldPath = ""
+		}
+		if editedPath, ok := edited[name]; ok {
+			if newText, err = readText(editedPath, name); err != nil {
+				return "", err
+			}
+			newMode = 0644
+			if executable[name] {
+				newMode = 0755
+			}
+		} else {
+			newPath = ""
+		}
+		patch := DiffFile(oldPath, newPath, oldText, newText)
+		if patch == nil && oldMode != newMode {
+			// Only the executable bit changed
+			patch = &FilePatch{OldPath: oldPath, NewPath: newPath}
+		}
+		if patch != nil {
+			patch.OldMode, patch.NewMode = oldMode, newMode
+			diff.WriteString(patch.String())
+		}
+	}
+	return diff.String(), nil
+}
+
+// readText reads a text file, binary files cannot be patched.
+func readText(path, name string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%s is a binary file and cannot be patched", name)
+	}
+	return string(data), nil
+}
+
+// ApplyPatch applies a unified diff to the package installed in dir. Files are
+// replaced rather than modified in place since they may be hardlinked to the store.
+func ApplyPatch(dir, patch string) error {
+	files, err := ParsePatch(patch)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.NewPath
+		if name == "" {
+			name = file.OldPath
+		}
+		if name == "" || path.IsAbs(name) || slices.Contains(strings.Split(name, "/"), "..") {
+			return fmt.Errorf("invalid path %q in patch", name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		text, mode := "", fs.FileMode(0644)
+		if file.OldPath != "" {
+			info, err := os.Stat(target)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if text, err = readText(target, name); err != nil {
+				return err
+			}
+			mode = info.Mode().Perm()
+		}
+		if file.NewMode != 0 {
+			mode = fs.FileMode(file.NewMode)
+		}
+		patched, err := file.Apply(text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if file.NewPath == "" {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+		if err := replaceFile(target, []byte(patched), mode); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// replaceFile writes a file through a temporary file renamed over it.
+func replaceFile(target string, data []byte, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(target), ".gopm-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), target)
+}
+
+// ReadPatchState reads the state written by gopm patch in an edit folder.
+func ReadPatchState(dir string) (*PatchState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PATCH_STATE))
+	if err != nil {
+		return nil, fmt.Errorf("%s was not created by 'gopm patch': %w", dir, err)
+	}
+	var state PatchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", PATCH_STATE, err)
+	}
+	return &state, nil
+}
+
+// Write writes the state in an edit folder.
+func (s *PatchState) Write(dir string) error {
+	data, err := json.MarshalIndent(s, "", INDENT)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, PATCH_STATE), data, 0644)
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	POLICY_FILE = "gopm-policy.json"
+	// TYPOSQUAT_MIN_LENGTH is the length below which names are too short to tell
+	// a typo from another package
+	TYPOSQUAT_MIN_LENGTH = 4
+)
+
+// popularPackages are widely installed packages whose names typosquatters imitate.
+var popularPackages = []string{
+	"@babel/core", "@babel/preset-env", "@types/node", "@types/react", "ajv", "angular",
+	"async", "autoprefixer", "axios", "babel-loader", "bluebird", "body-parser",
+	"chalk", "cheerio", "classnames", "commander", "cookie-parser", "cors",
+	"cross-env", "cross-spawn", "css-loader", "date-fns", "dayjs", "debug",
+	"dotenv", "esbuild", "eslint", "eslint-plugin-react", "event-stream", "express",
+	"fastify", "fs-extra", "glob", "graphql", "handlebars", "helmet",
+	"immer", "inquirer", "jest", "jquery", "js-yaml", "jsonwebtoken",
+	"koa", "lodash", "lodash.merge", "minimatch", "minimist", "mkdirp",
+	"mocha", "moment", "mongodb", "mongoose", "morgan", "mysql",
+	"nanoid", "next", "node-fetch", "nodemon", "passport", "postcss",
+	"prettier", "prop-types", "puppeteer", "ramda", "react", "react-dom",
+	"react-redux", "react-router", "react-router-dom", "redis", "redux", "request",
+	"rimraf", "rollup", "rxjs", "sass", "semver", "sequelize",
+	"sharp", "socket.io", "styled-components", "superagent", "supertest", "tailwindcss",
+	"through2", "tslib", "typescript", "underscore", "uuid", "validator",
+	"vite", "vue", "webpack", "webpack-cli", "winston", "yargs", "zod",
+}
+
+// wellKnownPackages are widely installed packages whose names happen to be one typo
+// away from a popular package. They are not reported as typosquats.
+var wellKnownPackages = []string{
+	"mssql", "mysql2", "nuxt", "preact", "sax", "through", "tslint", "uid",
+}
+
+// Policy restricts the dependencies accepted by gopm add and gopm dev. It is read
+// from gopm-policy.json at the root of the project.
+type Policy struct {
+	// Allow lists the only packages which may be added, by name or by a pattern such as @scope/*
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists the packages which may never be added
+	Deny []string `json:"deny,omitempty"`
+	// Registries are the URL prefixes dependencies may be downloaded from, covering
+	// the registry, tarball URLs and git repositories
+	Registries []string `json:"registries,omitempty"`
+	// Popular extends the packages protected against typosquatting
+	Popular []string `json:"popular,omitempty"`
+
+	// registry is where registry dependencies are downloaded from, set by LoadPolicy
+	registry string
+}
+
+// LoadPolicy reads the policy file set by the package-policy setting, gopm-policy.json
+// of the project by default. A missing default file is an empty policy.
+func LoadPolicy(config Config) (Policy, error) {
+	policy := Policy{registry: config.Registry()}
+	file := config.Get("package-policy", "")
+	if file == "" {
+		file = filepath.Join(GetCwd(), POLICY_FILE)
+		if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+			return policy, nil
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return policy, fmt.Errorf("failed to read the package policy: %w", err)
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("error decoding %s: %w", file, err)
+	}
+	return policy, nil
+}
+
+// CheckName returns an error when the policy does not allow a package name.
+func (p Policy) CheckName(name string) error {
+	if matchesPackage(p.Deny, name) {
+		return fmt.Errorf("%s is denied by the package policy", name)
+	}
+	if len(p.Allow) > 0 && !matchesPackage(p.Allow, name) {
+		return fmt.Errorf("%s is not in the allow list of the package policy", name)
+	}
+	return nil
+}
+
+// CheckSpec returns an error when the policy does not allow a dependency, before
+// it is downloaded. The names of git, tarball and local dependencies are only
+// known once fetched and must be checked with CheckName.
+func (p Policy) CheckSpec(spec *Spec) error {
+	for _, name := range []string{spec.Name, spec.Package} {
+		if name == "" {
+			continue
+		}
+		if err := p.CheckName(name); err != nil {
+			return err
+		}
+	}
+	if len(p.Registries) == 0 {
+		return nil
+	}
+	source := ""
+	switch spec.Type {
+	case SPEC_REGISTRY:
+		source = cmp.Or(p.registry, NPM_REGISTRY)
+	case SPEC_GIT, SPEC_TARBALL:
+		source = spec.URL
+	default:
+		// Local dependencies are not downloaded
+		return nil
+	}
+	if !slices.ContainsFunc(p.Registries, func(registry string) bool { return underURL(source, registry) }) {
+		return fmt.Errorf("%s is not downloaded from a registry approved by the package policy", source)
+	}
+	return nil
+}
+
+// Typosquat returns the popular package a name is one typo away from, empty when
+// the name is not suspicious. Packages allowed explicitly are trusted.
+func (p Policy) Typosquat(name string) string {
+	popular := slices.Concat(popularPackages, p.Popular)
+	if slices.Contains(popular, name) || slices.Contains(wellKnownPackages, name) || (len(p.Allow) > 0 && matchesPackage(p.Allow, name)) {
+		return ""
+	}
+	for _, candidate := range popular {
+		if len(candidate) >= TYPOSQUAT_MIN_LENGTH && editDistance(name, candidate) == 1 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// matchesPackage returns whether a package name matches one of a list of names
+// or patterns such as @scope/*.
+func matchesPackage(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// underURL returns whether a URL is prefix or one of its descendants, ignoring trailing slashes.
+func underURL(url, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return url == prefix || strings.HasPrefix(url, prefix+"/")
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters turning a into b.
+func editDistance(a, b string) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(a)][len(b)]
+}
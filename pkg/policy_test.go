@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicy ensures the package policy blocks denied packages, unapproved sources and typosquats
+func TestPolicy(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	policy, err := LoadPolicy(Config{})
+	assert.NoError(t, err, "the policy file is optional")
+	assert.Equal(t, Policy{registry: NPM_REGISTRY}, policy)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, POLICY_FILE), []byte(`{
+		"deny": ["event-stream", "@evil/*"],
+		"registries": ["https://registry.npmjs.org", "https://github.com/acme/"],
+		"popular": ["@acme/toolkit"]
+	}`), 0644))
+	policy, err = LoadPolicy(Config{})
+	assert.NoError(t, err)
+
+	check := func(arg string) error {
+		spec, err := ParseSpec(arg)
+		assert.NoError(t, err, arg)
+		return policy.CheckSpec(spec)
+	}
+	assert.NoError(t, check("lodash@^4"))
+	assert.ErrorContains(t, check("event-stream"), "denied")
+	assert.ErrorContains(t, check("@evil/pkg@1.0.0"), "denied", "scopes are denied with a pattern")
+	assert.ErrorContains(t, check("stream@npm:event-stream@3"), "denied", "aliases are checked against the registry name")
+	assert.NoError(t, check("github:acme/repo#v1"))
+	assert.ErrorContains(t, check("github:other/repo"), "approved")
+	assert.ErrorContains(t, check("https://example.com/pkg-1.0.0.tgz"), "approved")
+	assert.NoError(t, check("file:../lib"), "local dependencies are not downloaded")
+	policy, err = LoadPolicy(Config{"registry": "https://npm.acme.dev"})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, check("lodash@^4"), "approved", "registry packages come from the configured registry")
+
+	allowOnly := Policy{Allow: []string{"@acme/*", "react"}}
+	assert.NoError(t, allowOnly.CheckName("@acme/ui"))
+	assert.ErrorContains(t, allowOnly.CheckName("lodash"), "allow list")
+
+	assert.Equal(t, "lodash", policy.Typosquat("lodahs"), "adjacent characters swapped")
+	assert.Equal(t, "cross-env", policy.Typosquat("crossenv"))
+	assert.Equal(t, "@acme/toolkit", policy.Typosquat("@acme/tolkit"), "popular packages of the policy")
+	assert.Equal(t, "", policy.Typosquat("lodash"))
+	assert.Equal(t, "", policy.Typosquat("left-pad"))
+	for _, name := range []string{"preact", "mysql2", "tslint", "through"} {
+		assert.Equal(t, "", policy.Typosquat(name), "well-known packages close to a popular one are not typosquats")
+	}
+	assert.Equal(t, "", Policy{Allow: []string{"reacts"}}.Typosquat("reacts"), "allowed packages are trusted")
+
+	_, err = LoadPolicy(Config{"package-policy": filepath.Join(dir, "missing.json")})
+	assert.Error(t, err, "a policy file set explicitly must exist")
+}
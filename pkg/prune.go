@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Retain returns a copy of the lockfile keeping only the importers and direct
+// dependencies still declared by the root package.json and its workspaces.
+func (l *Lockfile) Retain(root *PackageJSON, workspaces []Workspace) *Lockfile {
+	manifests := map[string]*PackageJSON{ROOT_IMPORTER: root}
+	for _, w := range workspaces {
+		manifests[w.Dir] = w.Manifest
+	}
+	out := &Lockfile{LockfileVersion: l.LockfileVersion, Importers: map[string]*LockImporter{}, Packages: l.Packages, Overrides: l.Overrides}
+	for dir, importer := range l.Importers {
+		manifest, ok := manifests[dir]
+		if !ok {
+			continue
+		}
+		out.Importers[dir] = &LockImporter{
+			Dependencies:         retainDeclared(importer.Dependencies, manifest.Dependencies),
+			DevDependencies:      retainDeclared(importer.DevDependencies, manifest.DevDependencies),
+			OptionalDependencies: retainDeclared(importer.OptionalDependencies, manifest.OptionalDependencies),
+			PeerDependencies:     retainDeclared(importer.PeerDependencies, manifest.PeerDependencies),
+		}
+	}
+	return out
+}
+
+// retainDeclared keeps the edges whose name and specifier are still declared.
+func retainDeclared(edges map[string]LockDependency, declared map[string]string) map[string]LockDependency {
+	kept := map[string]LockDependency{}
+	for name, dep := range edges {
+		if spec, ok := declared[name]; ok && spec == dep.Specifier {
+			kept[name] = dep
+		}
+	}
+	return kept
+}
+
+// PlanPrune returns the locations, relative to the project, of the packages
+// found in the node_modules folders of the importers that are not part of the
+// layout or hold another version than the layout expects there, along with the
+// .bin links left dangling once they are removed.
+func PlanPrune(lock *Lockfile, layout Layout, links Links) ([]string, error) {
+	// Every expected location along with its parents, such as scope folders
+	expected, parents := map[string]bool{}, map[string]bool{}
+	for _, location := range slices.Concat(slices.Collect(maps.Keys(layout)), slices.Collect(maps.Keys(links))) {
+		expected[location] = true
+		for dir := path.Dir(location); dir != "."; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	versions := map[string]string{}
+	for location, key := range layout {
+		if locked, ok := lock.Packages[key]; ok {
+			versions[location] = locked.Version
+		}
+	}
+
+	p := &pruner{cwd: GetCwd(), expected: expected, parents: parents, versions: versions}
+	for dir := range lock.Importers {
+		if err := p.visit(modulePath(importerLocation(dir), "")); err != nil {
+			return nil, err
+		}
+	}
+	for _, binDir := range p.binDirs {
+		p.danglingBins(binDir)
+	}
+	slices.Sort(p.extraneous)
+	return p.extraneous, nil
+}
+
+// RemoveExtraneous moves the packages and .bin links which are not part of the layout
+// into the transaction, such as the packages nested in the previous version of a
+// replaced package. It returns their locations.
+func (t *Transaction) RemoveExtraneous(lock *Lockfile, layout Layout, links Links) ([]string, error) {
+	extraneous, err := PlanPrune(lock, layout, links)
+	if err != nil {
+		return nil, err
+	}
+	cwd := GetCwd()
+	for _, location := range extraneous {
+		if err := t.Replace(filepath.Join(cwd, filepath.FromSlash(location))); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", location, err)
+		}
+	}
+	return extraneous, nil
+}
+
+// pruner walks node_modules folders looking for extraneous packages.
+type pruner struct {
+	cwd        string
+	expected   map[string]bool
+	parents    map[string]bool
+	versions   map[string]string
+	extraneous []string
+	binDirs    []string
+}
+
+// visit checks the packages of a node_modules folder.
+func (p *pruner) visit(modules string) error {
+	entries, err := os.ReadDir(filepath.Join(p.cwd, filepath.FromSlash(modules)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		location := modules + "/" + entry.Name()
+		switch {
+		case entry.Name() == STAGING_DIR:
+			// Installs in progress stage their packages there
+		case entry.Name() == BIN_DIR:
+			p.binDirs = append(p.binDirs, location)
+		case entry.Name() == VIRTUAL_STORE && entry.IsDir():
+			if err := p.visitVirtualStore(location); err != nil {
+				return err
+			}
+		case strings.HasPrefix(entry.Name(), "."):
+			// Like npm, entries such as the .cache or .vite folders of tools are not packages
+		case strings.HasPrefix(entry.Name(), "@") && entry.IsDir():
+			scoped, err := os.ReadDir(filepath.Join(p.cwd, filepath.FromSlash(location)))
+			if err != nil {
+				return err
+			}
+			for _, pkg := range scoped {
+				if err := p.visitPackage(location+"/"+pkg.Name(), pkg); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := p.visitPackage(location, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// visitPackage checks a package folder or link and the node_modules folder nested in it.
+func (p *pruner) visitPackage(location string, entry os.DirEntry) error {
+	if !entry.IsDir() && entry.Type()&os.ModeSymlink == 0 {
+		return nil
+	}
+	if !p.expected[location] && !p.parents[location] {
+		p.extraneous = append(p.extraneous, location)
+		return nil
+	}
+	// A package left at an expected location by a previous layout would shadow the right one
+	if version, ok := p.versions[location]; ok && entry.IsDir() && InstalledVersion(filepath.Join(p.cwd, filepath.FromSlash(location))) != version {
+		p.extraneous = append(p.extraneous, location)
+		return nil
+	}
+	if entry.IsDir() {
+		return p.visit(modulePath(location, ""))
+	}
+	return nil
+}
+
+// visitVirtualStore checks the packages of the isolated virtual store.
+func (p *pruner) visitVirtualStore(store string) error {
+	entries, err := os.ReadDir(filepath.Join(p.cwd, filepath.FromSlash(store)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		location := store + "/" + entry.Name()
+		if !entry.IsDir() {
+			continue
+		}
+		if !p.parents[location] {
+			p.extraneous = append(p.extraneous, location)
+			continue
+		}
+		if err := p.visit(location + "/" + NODE_MODULE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// danglingBins finds the links of a .bin folder pointing to missing or extraneous packages.
+func (p *pruner) danglingBins(binDir string) {
+	dir := filepath.Join(p.cwd, filepath.FromSlash(binDir))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		link := filepath.Join(dir, entry.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		rel, err := filepath.Rel(p.cwd, target)
+		rel = filepath.ToSlash(rel)
+		extraneous := err == nil && slices.ContainsFunc(p.extraneous, func(location string) bool {
+			return rel == location || strings.HasPrefix(rel, location+"/")
+		})
+		if _, err := os.Stat(link); err != nil || extraneous {
+			p.extraneous = append(p.extraneous, binDir+"/"+entry.Name())
+		}
+	}
+}
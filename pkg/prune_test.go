@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPlanPrune ensures undeclared packages, nested or scoped, and their .bin links are extraneous, not tool caches
+func TestPlanPrune(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	registry := fakeRegistry{
+		"a":     {"1.0.0": nil},
+		"jest":  {"1.0.0": nil},
+		"@s/ok": {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{
+		Dependencies:    map[string]string{"a": "^1.0.0", "@s/ok": "^1.0.0"},
+		DevDependencies: map[string]string{"jest": "^1.0.0"},
+	})
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, location := range []string{
+		"node_modules/a", "node_modules/jest", "node_modules/@s/ok", "node_modules/@s/gone",
+		"node_modules/old", "node_modules/a/node_modules/stale",
+		"node_modules/.cache/babel-loader", "node_modules/a/node_modules/.vite",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, location), 0755))
+	}
+	for _, name := range []string{"a", "jest", "@s/ok"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", name, PACKAGE_JSON), []byte(`{"name":"`+name+`","version":"1.0.0"}`), 0644))
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", BIN_DIR), 0755))
+	assert.NoError(t, os.Symlink("../a/cli.js", filepath.Join(dir, "node_modules", BIN_DIR, "a")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "a", "cli.js"), nil, 0755))
+	assert.NoError(t, os.Symlink("../old/cli.js", filepath.Join(dir, "node_modules", BIN_DIR, "old")))
+	assert.NoError(t, os.Symlink("../jest/cli.js", filepath.Join(dir, "node_modules", BIN_DIR, "jest")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "jest", "cli.js"), nil, 0755))
+
+	// jest is no longer needed once dev dependencies are omitted
+	layout, links := PlanHoisted(lock.Retain(&PackageJSON{
+		Dependencies:    map[string]string{"a": "^1.0.0", "@s/ok": "^1.0.0"},
+		DevDependencies: map[string]string{"jest": "^1.0.0"},
+	}, nil).Omit([]string{OMIT_DEV}))
+	extraneous, err := PlanPrune(lock, layout, links)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"node_modules/.bin/jest",
+		"node_modules/.bin/old",
+		"node_modules/@s/gone",
+		"node_modules/a/node_modules/stale",
+		"node_modules/jest",
+		"node_modules/old",
+	}, extraneous)
+
+	// A package left at an expected location in another version is extraneous
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "a", PACKAGE_JSON), []byte(`{"name":"a","version":"0.9.0"}`), 0644))
+	extraneous, err = PlanPrune(lock, layout, links)
+	assert.NoError(t, err)
+	assert.Contains(t, extraneous, "node_modules/a")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "a", PACKAGE_JSON), []byte(`{"name":"a","version":"1.0.0"}`), 0644))
+
+	// Dependencies removed from package.json are extraneous as well
+	layout, links = PlanHoisted(lock.Retain(&PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}}, nil))
+	extraneous, err = PlanPrune(lock, layout, links)
+	assert.NoError(t, err)
+	assert.Contains(t, extraneous, "node_modules/@s/ok")
+	assert.Contains(t, extraneous, "node_modules/jest")
+}
+
+// TestRemoveExtraneous ensures packages nested in the previous version of a replaced package do not shadow the new layout
+func TestRemoveExtraneous(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	write := func(location, version string) {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, location), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, location, PACKAGE_JSON), []byte(`{"version":"`+version+`"}`), 0644))
+	}
+	write("node_modules/a", "1.0.0")
+	write("node_modules/a/node_modules/c", "1.0.0")
+	write("node_modules/c", "1.0.0")
+
+	// a@2 relies on the hoisted c@2
+	lock := NewLockfile()
+	lock.Packages["a@2.0.0"] = &LockPackage{Name: "a", Version: "2.0.0"}
+	lock.Packages["c@2.0.0"] = &LockPackage{Name: "c", Version: "2.0.0"}
+	layout := Layout{"node_modules/a": "a@2.0.0", "node_modules/c": "c@2.0.0"}
+
+	tx, err := BeginTransaction()
+	assert.NoError(t, err)
+	for location, version := range map[string]string{"node_modules/a": "2.0.0", "node_modules/c": "2.0.0"} {
+		staged, err := tx.Stage(location)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(staged, PACKAGE_JSON), []byte(`{"version":"`+version+`"}`), 0644))
+	}
+	assert.NoError(t, tx.Swap())
+	removed, err := tx.RemoveExtraneous(lock, layout, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node_modules/a/node_modules/c"}, removed)
+	assert.NoDirExists(t, filepath.Join(dir, "node_modules/a/node_modules/c"))
+
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, "1.0.0", InstalledVersion(filepath.Join(dir, "node_modules/a/node_modules/c")), "removals are rolled back")
+	assert.Equal(t, "1.0.0", InstalledVersion(filepath.Join(dir, "node_modules/a")))
+}
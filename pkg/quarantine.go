@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseAge parses an age such as 3d, 12h or 1w. A bare number is a number of minutes.
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if minutes, err := strconv.Atoi(value); err == nil {
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q", value)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q, expected a duration such as 3d or 12h", value)
+	}
+	return age, nil
+}
+
+// MinimumReleaseAge returns the minimum-release-age setting, zero when it is not set.
+func (c Config) MinimumReleaseAge() (time.Duration, error) {
+	value := c.Get("minimum-release-age", "")
+	if value == "" {
+		return 0, nil
+	}
+	age, err := ParseAge(value)
+	if err != nil {
+		return 0, fmt.Errorf("minimum-release-age: %w", err)
+	}
+	return age, nil
+}
+
+// Configure applies the resolution settings of the configuration to the resolver.
+func (r *Resolver) Configure(config Config) error {
+	age, err := config.MinimumReleaseAge()
+	if err != nil {
+		return err
+	}
+	r.MinimumReleaseAge, r.ReleaseAgeExclude = age, config.List("minimum-release-age-exclude")
+	r.Registry = config.Registry()
+	return nil
+}
+
+// quarantined returns whether the recent versions of a package are ignored. Packages
+// are excluded by name or by a pattern such as @scope/*.
+func (r *Resolver) quarantined(name string) bool {
+	return r.MinimumReleaseAge > 0 && !matchesPackage(r.ReleaseAgeExclude, name)
+}
+
+// released returns a copy of a package document without the versions published
+// less than MinimumReleaseAge ago. Versions without a publish time are kept, and
+// dist-tags pointing to a recent version fall back to the newest older one.
+func (r *Resolver) released(name string, body *BodyRegistery) *BodyRegistery {
+	if !r.quarantined(name) {
+		return body
+	}
+	cutoff := time.Now().Add(-r.MinimumReleaseAge)
+	released := &BodyRegistery{Name: body.Name, DistTags: map[string]string{}, Versions: map[string]Manifest{}, Time: body.Time}
+	for version, manifest := range body.Versions {
+		if published, err := time.Parse(time.RFC3339, body.Time[version]); err == nil && published.After(cutoff) {
+			continue
+		}
+		released.Versions[version] = manifest
+	}
+	versions := slices.Collect(maps.Keys(released.Versions))
+	for tag, version := range body.DistTags {
+		if _, ok := released.Versions[version]; !ok {
+			version = MaxSatisfying(versions, "<"+version)
+		}
+		if version != "" {
+			released.DistTags[tag] = version
+		}
+	}
+	return released
+}
+
+// PickVersion picks the version of a package matching a version, a range or a
+// dist-tag, following the release age and advisories of the resolver.
+func (r *Resolver) PickVersion(ctx context.Context, name, spec string) (string, error) {
+	body, err := r.Packument(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	version := r.pickVersion(name, body, spec, r.locked[name])
+	if version == "" {
+		return "", r.noVersion(name, spec)
+	}
+	return version, nil
+}
+
+// noVersion reports that no version of a package satisfies a range, mentioning
+// the release age when it left out the matching versions.
+func (r *Resolver) noVersion(name, spec string) error {
+	if r.quarantined(name) {
+		return failure(FAILURE_NOT_FOUND, "No version of %s satisfies %s and was published more than %s ago (minimum-release-age)", name, spec, formatAge(r.MinimumReleaseAge))
+	}
+	return failure(FAILURE_NOT_FOUND, "No version of %s satisfies %s", name, spec)
+}
+
+// formatAge formats an age in days when it is a whole number of days.
+func formatAge(age time.Duration) string {
+	if day := 24 * time.Hour; age%day == 0 {
+		return fmt.Sprintf("%dd", age/day)
+	}
+	return age.String()
+}
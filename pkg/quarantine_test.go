@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMinimumReleaseAge ensures recently published versions are ignored unless excluded
+func TestMinimumReleaseAge(t *testing.T) {
+	now := time.Now()
+	published := map[string]time.Time{
+		"1.0.0": now.Add(-30 * 24 * time.Hour),
+		"1.1.0": now.Add(-10 * 24 * time.Hour),
+		"1.2.0": now.Add(-time.Hour),
+	}
+	fetch := func(ctx context.Context, name string) (*BodyRegistery, error) {
+		body := &BodyRegistery{Name: name, DistTags: map[string]string{LATEST_TAG: "1.2.0"}, Versions: map[string]Manifest{}, Time: map[string]string{}}
+		for version, at := range published {
+			body.Versions[version] = Manifest{Name: name, Version: version, Dist: Dist{Tarball: fmt.Sprintf("https://registry.test/%s-%s.tgz", name, version)}}
+			body.Time[version] = at.UTC().Format(time.RFC3339)
+		}
+		return body, nil
+	}
+	resolver := NewResolver(nil)
+	resolver.Fetch = fetch
+	assert.NoError(t, resolver.Configure(Config{"minimum-release-age": "3d", "minimum-release-age-exclude": "@trusted/*, pinned"}))
+	assert.Equal(t, 72*time.Hour, resolver.MinimumReleaseAge)
+
+	lock, err := resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{
+		"a":            "^1.0.0",
+		"b":            "~1.0.0",
+		"@trusted/c":   "^1.0.0",
+		"pinned":       "^1.0.0",
+		"alias":        "npm:a@latest",
+		"@untrusted/d": "^1.0.0",
+	}}, nil)
+	assert.NoError(t, err)
+	versions := map[string]string{}
+	for name, dep := range lock.Importers[ROOT_IMPORTER].Dependencies {
+		versions[name] = lock.Packages[dep.Package].Version
+	}
+	assert.Equal(t, map[string]string{
+		"a":            "1.1.0",
+		"b":            "1.0.0",
+		"@trusted/c":   "1.2.0",
+		"pinned":       "1.2.0",
+		"alias":        "1.1.0",
+		"@untrusted/d": "1.1.0",
+	}, versions, "should fall back to the newest version old enough")
+
+	_, err = resolver.PickVersion(t.Context(), "a", "1.2.0")
+	assert.ErrorContains(t, err, "minimum-release-age", "an exact version too recent cannot be picked")
+
+	for value, expected := range map[string]time.Duration{"3d": 72 * time.Hour, "1w": 7 * 24 * time.Hour, "12h": 12 * time.Hour, "1440": 24 * time.Hour} {
+		age, err := ParseAge(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, age, value)
+	}
+	_, err = ParseAge("soon")
+	assert.Error(t, err)
+}
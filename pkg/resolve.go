@@ -0,0 +1,445 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// Resolver resolves the dependency graph of a project from the npm registry.
+type Resolver struct {
+	// Fetch gets the package document of a dependency
+	Fetch func(ctx context.Context, dependency string) (*BodyRegistery, error)
+	// Registry is the registry package documents are fetched from by default and
+	// tarball URLs point to when the registry does not give them
+	Registry string
+	// Store receives dependencies fetched from outside the registry
+	Store *Store
+	// Avoid lists advisories whose vulnerable versions are only picked when no
+	// other version satisfies a range
+	Avoid Advisories
+	// MinimumReleaseAge ignores the versions published more recently, except for
+	// the packages matching ReleaseAgeExclude
+	MinimumReleaseAge time.Duration
+	ReleaseAgeExclude []string
+
+	locked        map[string][]string
+	external      map[string]*LockPackage
+	packuments    map[string]*BodyRegistery
+	manifests     map[string]*Manifest
+	overrides     []Override
+	usedOverrides map[int]bool
+	// ancestors is the path through which each package was first reached. Packages are
+	// shared by their dependents, so nested overrides only see that path, the shallowest.
+	ancestors map[string][]overrideNode
+	mu        sync.Mutex
+}
+
+// resolveRequest is a dependency waiting to be resolved.
+type resolveRequest struct {
+	name     string
+	spec     string
+	optional bool
+	target   map[string]LockDependency
+	// parents are the ancestors of the dependency, nil for direct dependencies
+	parents []overrideNode
+	// dir is the directory, relative to the project, of the package.json declaring
+	// the dependency, which file: and link: paths are relative to
+	dir string
+}
+
+// NewResolver creates a resolver preferring the versions of a previous lockfile.
+func NewResolver(locked *Lockfile) *Resolver {
+	r := &Resolver{
+		Registry:   NPM_REGISTRY,
+		locked:     locked.LockedVersions(),
+		external:   locked.LockedExternal(),
+		packuments: map[string]*BodyRegistery{},
+		manifests:  map[string]*Manifest{},
+	}
+	r.Fetch = r.fetchPackument
+	return r
+}
+
+// fetchPackument gets the package document of a dependency from the registry of the resolver.
+func (r *Resolver) fetchPackument(ctx context.Context, dependency string) (*BodyRegistery, error) {
+	body := &BodyRegistery{}
+	if err := body.GetPackument(ctx, r.Registry, dependency); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Packument returns the package document of a dependency, fetching it once.
+func (r *Resolver) Packument(ctx context.Context, name string) (*BodyRegistery, error) {
+	r.mu.Lock()
+	body, ok := r.packuments[name]
+	r.mu.Unlock()
+	if ok {
+		return body, nil
+	}
+
+	body, err := r.Fetch(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.packuments[name] = body
+	r.mu.Unlock()
+	return body, nil
+}
+
+// prefetch fetches the package documents of dependencies concurrently.
+func (r *Resolver) prefetch(ctx context.Context, requests []resolveRequest) {
+	g := errgroup.Group{}
+	g.SetLimit(MAX_CONCURRENT_DOWNLOADS)
+	seen := map[string]bool{}
+	for _, req := range requests {
+		name, ok := registryName(req.name, req.spec)
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		g.Go(func() error {
+			// Errors are reported when the dependency is resolved
+			_, _ = r.Packument(ctx, name)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// Resolve resolves the dependencies of a package.json and of its workspaces into a lockfile.
+func (r *Resolver) Resolve(ctx context.Context, p *PackageJSON, workspaces []Workspace) (*Lockfile, error) {
+	overrides, err := p.ParseOverrides()
+	if err != nil {
+		return nil, err
+	}
+	r.overrides, r.usedOverrides, r.ancestors = overrides, map[int]bool{}, map[string][]overrideNode{}
+
+	lock := NewLockfile()
+	queue := lock.addImporter(ROOT_IMPORTER, p)
+
+	// Workspace packages are linked rather than fetched from the registry
+	linked := map[string]string{}
+	for _, w := range workspaces {
+		key := PackageKey(w.Manifest.Name, LINK_PROTOCOL+w.Dir)
+		lock.Packages[key] = &LockPackage{Name: w.Manifest.Name, Version: w.Manifest.Version, Resolved: LINK_PROTOCOL + w.Dir}
+		linked[w.Manifest.Name] = key
+		queue = append(queue, lock.addImporter(w.Dir, w.Manifest)...)
+	}
+
+	// Resolve the graph breadth first so that shallow dependencies pick versions first.
+	// Every dependency failing to resolve is reported, once, rather than only the first one.
+	failures := []error{}
+	failed := map[string]bool{}
+	for len(queue) > 0 {
+		r.prefetch(ctx, queue)
+		next := []resolveRequest{}
+		for _, req := range queue {
+			if key, ok := linkedWorkspace(lock, linked, req); ok {
+				req.target[req.name] = LockDependency{Specifier: req.spec, Package: key}
+				continue
+			}
+			request := PackageKey(req.name, req.spec)
+			if failed[request] {
+				continue
+			}
+			key, added, err := r.resolveDependency(ctx, lock, req.dir, req.name, r.override(ctx, req))
+			if err != nil {
+				// Interrupted resolutions stop at once rather than skipping optional dependencies
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if req.optional {
+					logrus.Warnf("Skipping optional dependency %s@%s: %v", req.name, req.spec, err)
+					continue
+				}
+				failed[request] = true
+				failures = append(failures, err)
+				continue
+			}
+			req.target[req.name] = LockDependency{Specifier: req.spec, Package: key}
+			if added != nil {
+				r.ancestors[key] = append(slices.Clone(req.parents), overrideNode{added.Name, added.Version})
+				next = append(next, r.dependenciesOf(ctx, key, added)...)
+			}
+		}
+		queue = next
+	}
+	if len(failures) > 0 {
+		return nil, errors.Join(failures...)
+	}
+
+	r.recordOverrides(lock)
+	lock.markFlags()
+	return lock, nil
+}
+
+// addImporter adds the direct dependencies of a project to the lockfile and returns their requests.
+// Peer dependencies of a project are installed so that it can be developed and tested.
+func (l *Lockfile) addImporter(dir string, p *PackageJSON) []resolveRequest {
+	importer := &LockImporter{
+		Dependencies:         map[string]LockDependency{},
+		DevDependencies:      map[string]LockDependency{},
+		OptionalDependencies: map[string]LockDependency{},
+		PeerDependencies:     map[string]LockDependency{},
+	}
+	l.Importers[dir] = importer
+	requests := slices.Concat(
+		requestsFor(p.Dependencies, false, importer.Dependencies),
+		requestsFor(p.DevDependencies, false, importer.DevDependencies),
+		requestsFor(p.OptionalDependencies, true, importer.OptionalDependencies),
+		requestsFor(p.PeerDependencies, false, importer.PeerDependencies),
+	)
+	for i := range requests {
+		requests[i].dir = importerLocation(dir)
+	}
+	return requests
+}
+
+// linkedWorkspace returns the workspace package a request resolves to, if any.
+func linkedWorkspace(lock *Lockfile, linked map[string]string, req resolveRequest) (string, bool) {
+	key, ok := linked[req.name]
+	if !ok || req.spec == "" {
+		return "", false
+	}
+	if strings.HasPrefix(req.spec, WORKSPACE_PROTOCOL) || req.spec == "*" {
+		return key, true
+	}
+	return key, Satisfies(lock.Packages[key].Version, req.spec)
+}
+
+// dependenciesOf returns the requests for the dependencies of a newly resolved package.
+func (r *Resolver) dependenciesOf(ctx context.Context, key string, p *LockPackage) []resolveRequest {
+	manifest, ok := r.manifests[key]
+	if !ok {
+		body, _ := r.Packument(ctx, p.Name)
+		m := body.Versions[p.Version]
+		manifest = &m
+	}
+
+	// Optional dependencies take precedence over regular ones of the same name
+	dependencies := maps.Clone(manifest.Dependencies)
+	for name := range manifest.OptionalDependencies {
+		delete(dependencies, name)
+	}
+	if len(dependencies) > 0 {
+		p.Dependencies = map[string]LockDependency{}
+	}
+	if len(manifest.OptionalDependencies) > 0 {
+		p.OptionalDependencies = map[string]LockDependency{}
+	}
+	requests := append(
+		requestsFor(dependencies, false, p.Dependencies),
+		requestsFor(manifest.OptionalDependencies, true, p.OptionalDependencies)...,
+	)
+	// The local dependencies of a local directory are relative to it
+	dir := ""
+	if source, ok := strings.CutPrefix(p.Resolved, FILE_PROTOCOL); ok {
+		if info, err := os.Stat(localPath(source)); err == nil && info.IsDir() {
+			dir = source
+		}
+	}
+	for i := range requests {
+		requests[i].parents = r.ancestors[key]
+		requests[i].dir = dir
+	}
+	return requests
+}
+
+// requestsFor creates resolve requests sorted by name.
+func requestsFor(dependencies map[string]string, optional bool, target map[string]LockDependency) []resolveRequest {
+	requests := []resolveRequest{}
+	for _, name := range slices.Sorted(maps.Keys(dependencies)) {
+		requests = append(requests, resolveRequest{name: name, spec: dependencies[name], optional: optional, target: target})
+	}
+	return requests
+}
+
+// resolveDependency resolves a dependency range to a package of the lockfile, local
+// paths being relative to dir. It returns the package when it was not part of the lockfile yet.
+func (r *Resolver) resolveDependency(ctx context.Context, lock *Lockfile, dir, name, spec string) (string, *LockPackage, error) {
+	if strings.HasPrefix(spec, WORKSPACE_PROTOCOL) {
+		return "", nil, fmt.Errorf("No workspace named %s", name)
+	}
+	parsed, err := ParseDependency(name, spec)
+	if err != nil {
+		return "", nil, err
+	}
+	if parsed.Type != SPEC_REGISTRY {
+		return r.resolveExternal(ctx, lock, dir, parsed)
+	}
+	// Aliased dependencies (npm:other@1) are resolved from the package they point to
+	name, spec = parsed.Package, parsed.Range
+
+	// Reuse a version already in the graph to limit duplicates
+	resolved := []string{}
+	for key, p := range lock.Packages {
+		if p.Name == name && key == PackageKey(p.Name, p.Version) && len(r.Avoid.Affecting(name, p.Version)) == 0 {
+			resolved = append(resolved, p.Version)
+		}
+	}
+	if version := MaxSatisfying(resolved, spec); version != "" {
+		return PackageKey(name, version), nil, nil
+	}
+
+	body, err := r.Packument(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	version := r.pickVersion(name, body, spec, r.locked[name])
+	if version == "" {
+		return "", nil, r.noVersion(name, spec)
+	}
+
+	manifest := body.Versions[version]
+	p := &LockPackage{
+		Name:      name,
+		Version:   version,
+		Resolved:  manifest.Dist.Tarball,
+		Integrity: manifest.Dist.Integrity,
+		Engines:   manifest.Engines,
+	}
+	if p.Resolved == "" {
+		p.Resolved = Tarball(r.Registry, name, version)
+	}
+	if p.Integrity == "" {
+		p.Integrity = manifest.Dist.Shasum
+	}
+	key := PackageKey(name, version)
+	lock.Packages[key] = p
+	return key, p, nil
+}
+
+// resolveExternal resolves a git, file, link or tarball dependency. The commit
+// or content locked for the same specifier is reused, except for local files
+// which are read again to pick up their changes.
+func (r *Resolver) resolveExternal(ctx context.Context, lock *Lockfile, dir string, spec *Spec) (string, *LockPackage, error) {
+	// Local paths are recorded relative to the project rather than to the declaring package.json,
+	// so local packages are not reused by specifier as the same one may point elsewhere
+	local := spec.Type == SPEC_FILE || spec.Type == SPEC_LINK
+	if local && !filepath.IsAbs(filepath.FromSlash(spec.Path)) {
+		spec.Path = path.Join(dir, spec.Path)
+	}
+	var p *LockPackage
+	var manifest *Manifest
+	if locked, ok := r.external[PackageKey(spec.Name, spec.Raw)]; ok && !local {
+		p = &LockPackage{Name: locked.Name, Version: locked.Version, Resolved: locked.Resolved, Integrity: locked.Integrity, Engines: locked.Engines}
+		manifest = &Manifest{Dependencies: locked.specifiers(locked.Dependencies), OptionalDependencies: locked.specifiers(locked.OptionalDependencies)}
+	} else {
+		if r.Store == nil && spec.Type != SPEC_LINK {
+			return "", nil, fmt.Errorf("cannot fetch %s@%s without a store", spec.Name, spec.Raw)
+		}
+		var err error
+		if p, manifest, err = r.Store.FetchSpec(ctx, spec); err != nil {
+			return "", nil, err
+		}
+		p.Engines = manifest.Engines
+	}
+	if p.Name == "" {
+		p.Name = spec.Name
+	}
+
+	key := PackageKey(p.Name, p.Resolved)
+	if _, ok := lock.Packages[key]; ok {
+		return key, nil, nil
+	}
+	lock.Packages[key] = p
+	r.manifests[key] = manifest
+	return key, p, nil
+}
+
+// registryName returns the registry package a dependency is resolved from, if any.
+func registryName(name, spec string) (string, bool) {
+	parsed, err := ParseDependency(name, spec)
+	if err != nil || parsed.Type != SPEC_REGISTRY || strings.HasPrefix(spec, WORKSPACE_PROTOCOL) {
+		return "", false
+	}
+	return parsed.Package, true
+}
+
+// pickVersion picks a released version of a package document, avoiding vulnerable versions when possible.
+func (r *Resolver) pickVersion(name string, body *BodyRegistery, spec string, locked []string) string {
+	body = r.released(name, body)
+	if len(r.Avoid[name]) > 0 {
+		if version := pickVersion(r.Avoid.safe(name, body), spec, locked); version != "" {
+			return version
+		}
+	}
+	return pickVersion(body, spec, locked)
+}
+
+// pickVersion picks the version of a package document matching a dist-tag or a
+// range, preferring a previously locked version then the latest dist-tag.
+func pickVersion(body *BodyRegistery, spec string, locked []string) string {
+	if version, ok := body.DistTags[spec]; ok {
+		return version
+	}
+	if !IsValidRange(spec) {
+		return ""
+	}
+	if version := MaxSatisfying(locked, spec); version != "" {
+		if _, ok := body.Versions[version]; ok {
+			return version
+		}
+	}
+	if latest := body.DistTags[LATEST_TAG]; latest != "" && Satisfies(latest, spec) {
+		return latest
+	}
+	return MaxSatisfying(slices.Collect(maps.Keys(body.Versions)), spec)
+}
+
+// markFlags flags the packages only reachable through dev or optional dependencies.
+func (l *Lockfile) markFlags() {
+	prodRoots, allRoots := []LockDependency{}, []LockDependency{}
+	for _, importer := range l.Importers {
+		prodRoots = slices.AppendSeq(prodRoots, maps.Values(importer.Dependencies))
+		prodRoots = slices.AppendSeq(prodRoots, maps.Values(importer.OptionalDependencies))
+		prodRoots = slices.AppendSeq(prodRoots, maps.Values(importer.PeerDependencies))
+		allRoots = slices.AppendSeq(allRoots, maps.Values(importer.Dependencies))
+		allRoots = slices.AppendSeq(allRoots, maps.Values(importer.DevDependencies))
+		allRoots = slices.AppendSeq(allRoots, maps.Values(importer.PeerDependencies))
+	}
+	nonDev := l.reachable(prodRoots, true)
+	nonOptional := l.reachable(allRoots, false)
+	for key, p := range l.Packages {
+		p.Dev = !nonDev[key]
+		p.Optional = !nonOptional[key]
+		// Workspaces are projects of their own
+		if target, ok := p.linkTarget(); ok && l.Importers[target] != nil {
+			p.Dev, p.Optional = false, false
+		}
+	}
+}
+
+// reachable returns the keys of the packages reachable from the roots.
+func (l *Lockfile) reachable(roots []LockDependency, optional bool) map[string]bool {
+	seen := map[string]bool{}
+	queue := slices.Clone(roots)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		p, ok := l.Packages[dep.Package]
+		if !ok || seen[dep.Package] {
+			continue
+		}
+		seen[dep.Package] = true
+		queue = slices.AppendSeq(queue, maps.Values(p.Dependencies))
+		if optional {
+			queue = slices.AppendSeq(queue, maps.Values(p.OptionalDependencies))
+		}
+	}
+	return seen
+}
@@ -0,0 +1,27 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolverRegistry ensures packages are resolved from the configured registry
+func TestResolverRegistry(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		w.Write([]byte(`{"name":"a","dist-tags":{"latest":"1.0.0"},"versions":{"1.0.0":{"name":"a","version":"1.0.0","dist":{"integrity":"sha512-a"}}}}`))
+	}))
+	defer server.Close()
+
+	resolver := NewResolver(nil)
+	assert.Equal(t, NPM_REGISTRY, resolver.Registry)
+	assert.NoError(t, resolver.Configure(Config{"registry": server.URL}))
+	lock, err := resolver.Resolve(t.Context(), &PackageJSON{Dependencies: map[string]string{"a": "^1.0.0"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a"}, requests)
+	assert.Equal(t, server.URL+"/a/-/a-1.0.0.tgz", lock.Packages["a@1.0.0"].Resolved, "tarballs missing from the packument are downloaded from the registry")
+}
@@ -0,0 +1,403 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SBOM formats
+const (
+	SBOM_CYCLONEDX    = "cyclonedx"
+	SBOM_SPDX         = "spdx"
+	CYCLONEDX_VERSION = "1.5"
+	SPDX_VERSION      = "SPDX-2.3"
+	SPDX_NOASSERTION  = "NOASSERTION"
+)
+
+// spdxInvalid matches the characters not allowed in SPDX identifiers.
+var spdxInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// SBOM is the software bill of materials of a project, built from its lockfile.
+type SBOM struct {
+	Name    string
+	Version string
+	// Dependencies are the direct dependencies of the root project
+	Dependencies []SBOMDependency
+	// Components are the packages of the lockfile, sorted by key
+	Components []SBOMComponent
+	Created    time.Time
+}
+
+// SBOMComponent is a package of the dependency graph.
+type SBOMComponent struct {
+	// Package is the key of the package in the lockfile
+	Package   string
+	Name      string
+	Version   string
+	Purl      string
+	Resolved  string
+	Integrity string
+	// License is an SPDX expression, empty when the package is not installed
+	License string
+	// Dev is set for packages only required by devDependencies
+	Dev          bool
+	Optional     bool
+	Dependencies []SBOMDependency
+}
+
+// SBOMDependency is a dependency edge, along with the section of package.json declaring it.
+type SBOMDependency struct {
+	Package string
+	Section string
+}
+
+// SBOM builds the bill of materials of the root project from the lockfile and the
+// licenses of the installed packages.
+func (l *Lockfile) SBOM(root *PackageJSON, licenses []PackageLicense) *SBOM {
+	found := map[string]string{}
+	for _, license := range licenses {
+		found[license.Package] = license.License
+	}
+	sbom := &SBOM{Name: root.Name, Version: root.Version, Components: []SBOMComponent{}, Created: time.Now().UTC()}
+	if importer := l.Importers[ROOT_IMPORTER]; importer != nil {
+		sbom.Dependencies = l.sbomDependencies(importer.sections())
+	}
+	for _, key := range slices.Sorted(maps.Keys(l.Packages)) {
+		p := l.Packages[key]
+		sections := map[string]map[string]LockDependency{DEPENDENCIES: p.Dependencies, OPTIONAL_DEPENDENCIES: p.OptionalDependencies}
+		// Workspaces depend on the packages of their importer
+		if target, ok := p.linkTarget(); ok && l.Importers[target] != nil {
+			sections = l.Importers[target].sections()
+		}
+		sbom.Components = append(sbom.Components, SBOMComponent{
+			Package:      key,
+			Name:         p.Name,
+			Version:      p.Version,
+			Purl:         Purl(p.Name, p.Version, l.externalSource(key, p)),
+			Resolved:     p.Resolved,
+			Integrity:    p.Integrity,
+			License:      found[key],
+			Dev:          p.Dev,
+			Optional:     p.Optional,
+			Dependencies: l.sbomDependencies(sections),
+		})
+	}
+	return sbom
+}
+
+// sbomDependencies lists the edges of package.json sections leading to packages of the lockfile, sorted by package.
+func (l *Lockfile) sbomDependencies(sections map[string]map[string]LockDependency) []SBOMDependency {
+	dependencies := []SBOMDependency{}
+	for section, edges := range sections {
+		for _, edge := range edges {
+			if _, ok := l.Packages[edge.Package]; ok {
+				dependencies = append(dependencies, SBOMDependency{edge.Package, section})
+			}
+		}
+	}
+	slices.SortFunc(dependencies, func(a, b SBOMDependency) int {
+		return strings.Compare(a.Package+" "+a.Section, b.Package+" "+b.Section)
+	})
+	return slices.CompactFunc(dependencies, func(a, b SBOMDependency) bool { return a.Package == b.Package })
+}
+
+// externalSource returns the resolved URL of packages fetched from git or a tarball
+// URL rather than the registry, empty for other packages.
+func (l *Lockfile) externalSource(key string, p *LockPackage) string {
+	if key == PackageKey(p.Name, p.Version) || !strings.Contains(p.Resolved, "://") {
+		return ""
+	}
+	return p.Resolved
+}
+
+// Purl returns the package URL of an npm package such as pkg:npm/%40scope/name@1.0.0.
+// Packages fetched from git or a tarball URL record it as a qualifier.
+func Purl(name, version, source string) string {
+	purl := "pkg:npm/" + strings.Replace(name, "@", "%40", 1) + "@" + url.PathEscape(version)
+	switch {
+	case strings.HasPrefix(source, "git"):
+		purl += "?vcs_url=" + url.QueryEscape(source)
+	case source != "":
+		purl += "?download_url=" + url.QueryEscape(source)
+	}
+	return purl
+}
+
+// sbomHash is a digest of a tarball, hex encoded.
+type sbomHash struct {
+	Algorithm string
+	Hex       string
+}
+
+// integrityHashes decodes the digests of an integrity string or a hex sha1 shasum.
+func integrityHashes(integrity string) []sbomHash {
+	hashes := []sbomHash{}
+	for _, entry := range strings.Fields(integrity) {
+		algorithm, digest, ok := strings.Cut(entry, "-")
+		if !ok {
+			hashes = append(hashes, sbomHash{"sha1", entry})
+			continue
+		}
+		if data, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			hashes = append(hashes, sbomHash{algorithm, hex.EncodeToString(data)})
+		}
+	}
+	return hashes
+}
+
+// CycloneDXDocument is a CycloneDX JSON document.
+type CycloneDXDocument struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     CycloneDXMetadata     `json:"metadata"`
+	Components   []CycloneDXComponent  `json:"components"`
+	Dependencies []CycloneDXDependency `json:"dependencies"`
+}
+
+// CycloneDXMetadata describes the project and the tool of a CycloneDX document.
+type CycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []CycloneDXComponent `json:"components"`
+	} `json:"tools"`
+	Component CycloneDXComponent `json:"component"`
+}
+
+// CycloneDXComponent is a component of a CycloneDX document.
+type CycloneDXComponent struct {
+	Type               string               `json:"type"`
+	BOMRef             string               `json:"bom-ref,omitempty"`
+	Group              string               `json:"group,omitempty"`
+	Name               string               `json:"name"`
+	Version            string               `json:"version,omitempty"`
+	Scope              string               `json:"scope,omitempty"`
+	Purl               string               `json:"purl,omitempty"`
+	Hashes             []CycloneDXHash      `json:"hashes,omitempty"`
+	Licenses           []CycloneDXLicense   `json:"licenses,omitempty"`
+	ExternalReferences []CycloneDXReference `json:"externalReferences,omitempty"`
+	Properties         []CycloneDXProperty  `json:"properties,omitempty"`
+}
+
+// CycloneDXHash is a digest of a component.
+type CycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// CycloneDXLicense is the license of a component as an SPDX expression.
+type CycloneDXLicense struct {
+	Expression string `json:"expression"`
+}
+
+// CycloneDXReference is a URL related to a component.
+type CycloneDXReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// CycloneDXProperty is a name-value pair attached to a component.
+type CycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CycloneDXDependency lists the components a component depends on.
+type CycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// CycloneDX returns the bill of materials as a CycloneDX document. Packages only
+// required by devDependencies are excluded from the runtime scope.
+func (s *SBOM) CycloneDX() *CycloneDXDocument {
+	rootRef := "root"
+	doc := &CycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  CYCLONEDX_VERSION,
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: CycloneDXMetadata{
+			Timestamp: s.Created.Format(time.RFC3339),
+			Component: CycloneDXComponent{Type: "application", BOMRef: rootRef, Name: s.Name, Version: s.Version, Purl: Purl(s.Name, s.Version, "")},
+		},
+		Components:   []CycloneDXComponent{},
+		Dependencies: []CycloneDXDependency{{Ref: rootRef, DependsOn: sbomRefs(s.Dependencies)}},
+	}
+	doc.Metadata.Tools.Components = []CycloneDXComponent{{Type: "application", Name: PACKAGE_MANAGER, Version: VERSION}}
+
+	for _, c := range s.Components {
+		component := CycloneDXComponent{Type: "library", BOMRef: c.Package, Name: c.Name, Version: c.Version, Scope: "required", Purl: c.Purl}
+		if scope, name, ok := strings.Cut(c.Name, "/"); ok && strings.HasPrefix(c.Name, "@") {
+			component.Group, component.Name = scope, name
+		}
+		switch {
+		case c.Dev:
+			component.Scope = "excluded"
+		case c.Optional:
+			component.Scope = "optional"
+		}
+		for _, h := range integrityHashes(c.Integrity) {
+			component.Hashes = append(component.Hashes, CycloneDXHash{strings.Replace(strings.ToUpper(h.Algorithm), "SHA", "SHA-", 1), h.Hex})
+		}
+		if c.License != "" && c.License != LICENSE_UNKNOWN {
+			component.Licenses = []CycloneDXLicense{{c.License}}
+		}
+		if strings.Contains(c.Resolved, "://") {
+			component.ExternalReferences = []CycloneDXReference{{"distribution", c.Resolved}}
+		}
+		component.Properties = []CycloneDXProperty{{"cdx:npm:package:development", fmt.Sprint(c.Dev)}}
+		doc.Components = append(doc.Components, component)
+		doc.Dependencies = append(doc.Dependencies, CycloneDXDependency{Ref: c.Package, DependsOn: sbomRefs(c.Dependencies)})
+	}
+	return doc
+}
+
+// sbomRefs returns the packages of dependency edges.
+func sbomRefs(dependencies []SBOMDependency) []string {
+	refs := []string{}
+	for _, dependency := range dependencies {
+		refs = append(refs, dependency.Package)
+	}
+	return refs
+}
+
+// SPDXDocument is an SPDX JSON document.
+type SPDXDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []SPDXPackage      `json:"packages"`
+	Relationships []SPDXRelationship `json:"relationships"`
+}
+
+// SPDXPackage is a package of an SPDX document.
+type SPDXPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []SPDXChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded      string            `json:"licenseConcluded,omitempty"`
+	LicenseDeclared       string            `json:"licenseDeclared,omitempty"`
+	CopyrightText         string            `json:"copyrightText,omitempty"`
+	ExternalRefs          []SPDXExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose"`
+}
+
+// SPDXChecksum is a digest of a package.
+type SPDXChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+// SPDXExternalRef identifies a package outside the document, such as its purl.
+type SPDXExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// SPDXRelationship relates two elements of an SPDX document.
+type SPDXRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX returns the bill of materials as an SPDX document. Dev and optional
+// dependencies are told apart by the type of their relationship.
+func (s *SBOM) SPDX() *SPDXDocument {
+	name := s.Name
+	if name == "" {
+		name = "project"
+	}
+	rootID := "SPDXRef-Root"
+	doc := &SPDXDocument{
+		SPDXVersion:       SPDX_VERSION,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", spdxInvalid.ReplaceAllString(name, "-"), newUUID()),
+		Packages: []SPDXPackage{{
+			Name:                  name,
+			SPDXID:                rootID,
+			VersionInfo:           s.Version,
+			DownloadLocation:      SPDX_NOASSERTION,
+			PrimaryPackagePurpose: "APPLICATION",
+		}},
+		Relationships: []SPDXRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", rootID}},
+	}
+	doc.CreationInfo.Created = s.Created.Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: gopm-" + VERSION}
+
+	relate := func(from string, dependencies []SBOMDependency) {
+		for _, dependency := range dependencies {
+			to := spdxID(dependency.Package)
+			switch dependency.Section {
+			case DEV_DEPENDENCIES:
+				doc.Relationships = append(doc.Relationships, SPDXRelationship{to, "DEV_DEPENDENCY_OF", from})
+			case OPTIONAL_DEPENDENCIES:
+				doc.Relationships = append(doc.Relationships, SPDXRelationship{to, "OPTIONAL_DEPENDENCY_OF", from})
+			default:
+				doc.Relationships = append(doc.Relationships, SPDXRelationship{from, "DEPENDS_ON", to})
+			}
+		}
+	}
+	relate(rootID, s.Dependencies)
+	for _, c := range s.Components {
+		p := SPDXPackage{
+			Name:                  c.Name,
+			SPDXID:                spdxID(c.Package),
+			VersionInfo:           c.Version,
+			DownloadLocation:      SPDX_NOASSERTION,
+			LicenseConcluded:      SPDX_NOASSERTION,
+			LicenseDeclared:       SPDX_NOASSERTION,
+			CopyrightText:         SPDX_NOASSERTION,
+			ExternalRefs:          []SPDXExternalRef{{"PACKAGE-MANAGER", "purl", c.Purl}},
+			PrimaryPackagePurpose: "LIBRARY",
+		}
+		if strings.Contains(c.Resolved, "://") {
+			p.DownloadLocation = c.Resolved
+		}
+		if c.License != "" && c.License != LICENSE_UNKNOWN {
+			p.LicenseDeclared = c.License
+		}
+		for _, h := range integrityHashes(c.Integrity) {
+			p.Checksums = append(p.Checksums, SPDXChecksum{strings.ToUpper(h.Algorithm), h.Hex})
+		}
+		doc.Packages = append(doc.Packages, p)
+		relate(p.SPDXID, c.Dependencies)
+	}
+	return doc
+}
+
+// spdxID returns the SPDX identifier of a package of the lockfile.
+func spdxID(key string) string {
+	return "SPDXRef-Package-" + strings.Trim(spdxInvalid.ReplaceAllString(key, "-"), "-")
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSBOM ensures the bill of materials lists purls, hashes, licenses and dependencies
+func TestSBOM(t *testing.T) {
+	lock := NewLockfile()
+	lock.Importers[ROOT_IMPORTER] = &LockImporter{
+		Dependencies:    map[string]LockDependency{"@scope/a": {Specifier: "^1.0.0", Package: "@scope/a@1.0.0"}, "c": {Specifier: "github:user/c", Package: "c@git+https://github.com/user/c.git#abc"}},
+		DevDependencies: map[string]LockDependency{"jest": {Specifier: "^29.0.0", Package: "jest@29.0.0"}},
+	}
+	lock.Packages["@scope/a@1.0.0"] = &LockPackage{Name: "@scope/a", Version: "1.0.0", Resolved: "https://registry.npmjs.org/@scope/a/-/a-1.0.0.tgz", Integrity: "sha512-3q2+7w==",
+		Dependencies: map[string]LockDependency{"b": {Specifier: "^2.0.0", Package: "b@2.0.0"}}}
+	lock.Packages["b@2.0.0"] = &LockPackage{Name: "b", Version: "2.0.0", Resolved: "https://registry.npmjs.org/b/-/b-2.0.0.tgz", Integrity: "0a0b"}
+	lock.Packages["c@git+https://github.com/user/c.git#abc"] = &LockPackage{Name: "c", Version: "0.1.0", Resolved: "git+https://github.com/user/c.git#abc"}
+	lock.Packages["jest@29.0.0"] = &LockPackage{Name: "jest", Version: "29.0.0", Resolved: "https://registry.npmjs.org/jest/-/jest-29.0.0.tgz", Dev: true}
+
+	sbom := lock.SBOM(&PackageJSON{Name: "app", Version: "1.0.0"}, []PackageLicense{{Package: "b@2.0.0", License: "MIT"}})
+	assert.Equal(t, []SBOMDependency{
+		{"@scope/a@1.0.0", DEPENDENCIES},
+		{"c@git+https://github.com/user/c.git#abc", DEPENDENCIES},
+		{"jest@29.0.0", DEV_DEPENDENCIES},
+	}, sbom.Dependencies)
+	assert.Equal(t, "pkg:npm/%40scope/a@1.0.0", sbom.Components[0].Purl)
+	assert.Equal(t, "pkg:npm/c@0.1.0?vcs_url=git%2Bhttps%3A%2F%2Fgithub.com%2Fuser%2Fc.git%23abc", sbom.Components[2].Purl)
+
+	cyclonedx := sbom.CycloneDX()
+	a := cyclonedx.Components[0]
+	assert.Equal(t, "@scope", a.Group)
+	assert.Equal(t, "a", a.Name)
+	assert.Equal(t, "required", a.Scope)
+	assert.Equal(t, []CycloneDXHash{{"SHA-512", "deadbeef"}}, a.Hashes)
+	assert.Equal(t, []CycloneDXHash{{"SHA-1", "0a0b"}}, cyclonedx.Components[1].Hashes, "legacy shasums are hex sha1")
+	assert.Equal(t, []CycloneDXLicense{{"MIT"}}, cyclonedx.Components[1].Licenses)
+	assert.Equal(t, "excluded", cyclonedx.Components[3].Scope, "dev packages are not part of the runtime")
+	assert.Contains(t, cyclonedx.Dependencies, CycloneDXDependency{Ref: "@scope/a@1.0.0", DependsOn: []string{"b@2.0.0"}})
+
+	spdx := sbom.SPDX()
+	assert.Len(t, spdx.Packages, 5, "the project and its packages")
+	assert.Equal(t, "MIT", spdx.Packages[2].LicenseDeclared)
+	assert.Equal(t, SPDX_NOASSERTION, spdx.Packages[1].LicenseDeclared, "packages without an installed license")
+	assert.Contains(t, spdx.Relationships, SPDXRelationship{"SPDXRef-Root", "DEPENDS_ON", "SPDXRef-Package-scope-a-1.0.0"})
+	assert.Contains(t, spdx.Relationships, SPDXRelationship{"SPDXRef-Package-jest-29.0.0", "DEV_DEPENDENCY_OF", "SPDXRef-Root"})
+	assert.Contains(t, spdx.Relationships, SPDXRelationship{"SPDXRef-Package-scope-a-1.0.0", "DEPENDS_ON", "SPDXRef-Package-b-2.0.0"})
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	BIN_DIR = ".bin"
+	// SCRIPT_KILL_DELAY is how long an interrupted script may take to exit before it is killed
+	SCRIPT_KILL_DELAY = 5 * time.Second
+)
+
+// Bins returns the executables declared by the bin field of package.json.
+func (p *PackageJSON) Bins() map[string]string {
+	if len(p.Bin) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(p.Bin, &single); err == nil {
+		return map[string]string{path.Base(p.Name): single}
+	}
+	var bins map[string]string
+	if err := json.Unmarshal(p.Bin, &bins); err != nil {
+		return nil
+	}
+	return bins
+}
+
+// LinkBins links the executables of the packages at the top of the node_modules
+// folder of dir into node_modules/.bin.
+func LinkBins(dir string) error {
+	modulesPath := filepath.Join(dir, NODE_MODULE)
+	binPath := filepath.Join(modulesPath, BIN_DIR)
+	for _, name := range topLevelPackages(modulesPath) {
+		manifest, err := ReadManifest(filepath.Join(modulesPath, filepath.FromSlash(name)))
+		if err != nil {
+			continue
+		}
+		for bin, target := range manifest.Bins() {
+			bin = path.Base(bin)
+			if bin == "." || bin == ".." || bin == "/" {
+				continue
+			}
+			relTarget := filepath.Join("..", filepath.FromSlash(name), filepath.FromSlash(path.Clean("/"+target)))
+			if err := os.MkdirAll(binPath, 0755); err != nil {
+				return err
+			}
+			linkPath := filepath.Join(binPath, bin)
+			if existing, err := os.Readlink(linkPath); err == nil && existing == relTarget {
+				continue
+			}
+			os.Remove(linkPath)
+			if err := os.Symlink(relTarget, linkPath); err != nil {
+				return fmt.Errorf("failed to link executable %s: %w", bin, err)
+			}
+			// Executables are often published without the executable bit
+			if info, err := os.Stat(linkPath); err == nil {
+				_ = os.Chmod(linkPath, info.Mode()|0111)
+			}
+		}
+	}
+	return nil
+}
+
+// topLevelPackages returns the names of the packages installed at the top of a
+// node_modules folder, including scoped ones.
+func topLevelPackages(modulesPath string) []string {
+	names := []string{}
+	entries, err := os.ReadDir(modulesPath)
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !strings.HasPrefix(name, "@") {
+			names = append(names, name)
+			continue
+		}
+		scoped, err := os.ReadDir(filepath.Join(modulesPath, name))
+		if err != nil {
+			continue
+		}
+		for _, s := range scoped {
+			names = append(names, name+"/"+s.Name())
+		}
+	}
+	return names
+}
+
+// RunScript runs a script of the package.json located in dir, preceded by its
+// pre script and followed by its post script when they exist.
+func RunScript(ctx context.Context, dir string, manifest *PackageJSON, script string, args []string) error {
+	command, ok := manifest.Scripts[script]
+	if !ok {
+		return fmt.Errorf("Missing script: %s", script)
+	}
+	if pre, ok := manifest.Scripts["pre"+script]; ok {
+		if err := runShell(ctx, dir, manifest, "pre"+script, pre); err != nil {
+			return err
+		}
+	}
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	if err := runShell(ctx, dir, manifest, script, command); err != nil {
+		return err
+	}
+	if post, ok := manifest.Scripts["post"+script]; ok {
+		return runShell(ctx, dir, manifest, "post"+script, post)
+	}
+	return nil
+}
+
+// runShell runs a command in the system shell with the executables of every
+// node_modules/.bin folder between dir and the project root in the PATH. When ctx
+// is cancelled, the script is interrupted then killed after SCRIPT_KILL_DELAY.
+func runShell(ctx context.Context, dir string, manifest *PackageJSON, event, command string) error {
+	fmt.Printf("\n> %s@%s %s\n> %s\n\n", manifest.Name, manifest.Version, event, command)
+
+	paths := []string{}
+	root := GetCwd()
+	for current := dir; ; current = filepath.Dir(current) {
+		paths = append(paths, filepath.Join(current, NODE_MODULE, BIN_DIR))
+		if current == root || current == filepath.Dir(current) {
+			break
+		}
+	}
+	paths = append(paths, os.Getenv("PATH"))
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/d", "/s", "/c", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	}
+	cmd.WaitDelay = SCRIPT_KILL_DELAY
+	cmd.Dir = dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PATH="+strings.Join(paths, string(os.PathListSeparator)),
+		"npm_lifecycle_event="+event,
+		"npm_package_name="+manifest.Name,
+		"npm_package_version="+manifest.Version,
+	)
+	if err := cmd.Run(); err != nil {
+		return failure(FAILURE_SCRIPT, "script %s of %s failed: %w", event, manifest.Name, err)
+	}
+	return nil
+}
+
+// shellQuote quotes an argument for the system shell.
+func shellQuote(arg string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
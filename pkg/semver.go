@@ -0,0 +1,380 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is a representation of a semantic version (https://semver.org).
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+}
+
+// Range is a representation of a npm version range: a union (||) of
+// comparator sets which must all match for the set to match.
+type Range [][]comparator
+
+type comparator struct {
+	op      string
+	version *Version
+}
+
+// partial is a version in which some parts may be omitted or wildcards.
+type partial struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+var (
+	versionRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	partialRegexp = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	operatorSpace = regexp.MustCompile(`(<=|>=|<|>|=|~|\^)\s+`)
+	hyphenRegexp  = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+)
+
+// ParseVersion parses a semantic version such as 1.2.3 or v1.2.3-beta.1.
+func ParseVersion(version string) (*Version, error) {
+	m := versionRegexp.FindStringSubmatch(strings.TrimPrefix(strings.TrimSpace(version), "="))
+	if m == nil {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	v := &Version{}
+	v.Major, _ = strconv.ParseUint(m[1], 10, 64)
+	v.Minor, _ = strconv.ParseUint(m[2], 10, 64)
+	v.Patch, _ = strconv.ParseUint(m[3], 10, 64)
+	if m[4] != "" {
+		v.Prerelease = strings.Split(m[4], ".")
+	}
+	return v, nil
+}
+
+// String returns the canonical representation of the version.
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is lower, equal or greater than o.
+func (v *Version) Compare(o *Version) int {
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	// A version without prerelease has a higher precedence
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.Prerelease) < len(o.Prerelease):
+		return -1
+	case len(v.Prerelease) > len(o.Prerelease):
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifier compares two prerelease identifiers.
+func compareIdentifier(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an == bn {
+			return 0
+		}
+		if an < bn {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		// Numeric identifiers have a lower precedence than alphanumeric ones
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// ParseRange parses a npm version range such as ^1.2.0, ~1.2, 1.x, >=1.0.0 <2.0.0,
+// 1.0.0 - 2.0.0 or any union of them separated by ||.
+func ParseRange(rng string) (Range, error) {
+	var r Range
+	for _, part := range strings.Split(rng, "||") {
+		set, err := parseComparatorSet(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", rng, err)
+		}
+		r = append(r, set)
+	}
+	return r, nil
+}
+
+// parseComparatorSet parses a set of space separated comparators.
+func parseComparatorSet(set string) ([]comparator, error) {
+	if m := hyphenRegexp.FindStringSubmatch(set); m != nil {
+		return parseHyphen(m[1], m[2])
+	}
+	set = operatorSpace.ReplaceAllString(set, "$1")
+	comparators := []comparator{}
+	for _, token := range strings.Fields(set) {
+		c, err := parseComparator(token)
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, c...)
+	}
+	return comparators, nil
+}
+
+// parseHyphen desugars a hyphen range (from - to) into comparators.
+func parseHyphen(from, to string) ([]comparator, error) {
+	lower, err := parsePartial(from)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := parsePartial(to)
+	if err != nil {
+		return nil, err
+	}
+	comparators := []comparator{}
+	if lower.major >= 0 {
+		comparators = append(comparators, comparator{">=", lower.floor()})
+	}
+	switch {
+	case upper.major < 0:
+	case upper.minor < 0:
+		comparators = append(comparators, comparator{"<", upperBound(upper.major+1, 0, 0)})
+	case upper.patch < 0:
+		comparators = append(comparators, comparator{"<", upperBound(upper.major, upper.minor+1, 0)})
+	default:
+		comparators = append(comparators, comparator{"<=", upper.floor()})
+	}
+	return comparators, nil
+}
+
+// parseComparator desugars a single range token (^1.2.3, ~1.2, >=1.x, ...) into comparators.
+func parseComparator(token string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "<", ">", "=", "~>", "~", "^"} {
+		if strings.HasPrefix(token, prefix) {
+			op, token = prefix, token[len(prefix):]
+			break
+		}
+	}
+	p, err := parsePartial(token)
+	if err != nil {
+		return nil, err
+	}
+	if p.major < 0 {
+		if op == "<" || op == ">" {
+			// Nothing is lower or greater than everything
+			return []comparator{{"<", upperBound(0, 0, 0)}}, nil
+		}
+		return []comparator{}, nil
+	}
+
+	switch op {
+	case "^":
+		switch {
+		case p.major > 0 || p.minor < 0:
+			return []comparator{{">=", p.floor()}, {"<", upperBound(p.major+1, 0, 0)}}, nil
+		case p.minor > 0 || p.patch < 0:
+			return []comparator{{">=", p.floor()}, {"<", upperBound(0, p.minor+1, 0)}}, nil
+		default:
+			return []comparator{{">=", p.floor()}, {"<", upperBound(0, 0, p.patch+1)}}, nil
+		}
+	case "~", "~>":
+		if p.minor < 0 {
+			return []comparator{{">=", p.floor()}, {"<", upperBound(p.major+1, 0, 0)}}, nil
+		}
+		return []comparator{{">=", p.floor()}, {"<", upperBound(p.major, p.minor+1, 0)}}, nil
+	case "", "=":
+		switch {
+		case p.minor < 0:
+			return []comparator{{">=", p.floor()}, {"<", upperBound(p.major+1, 0, 0)}}, nil
+		case p.patch < 0:
+			return []comparator{{">=", p.floor()}, {"<", upperBound(p.major, p.minor+1, 0)}}, nil
+		}
+		return []comparator{{"=", p.floor()}}, nil
+	case ">":
+		switch {
+		case p.minor < 0:
+			return []comparator{{">=", upperBound(p.major+1, 0, 0)}}, nil
+		case p.patch < 0:
+			return []comparator{{">=", upperBound(p.major, p.minor+1, 0)}}, nil
+		}
+	case "<=":
+		switch {
+		case p.minor < 0:
+			return []comparator{{"<", upperBound(p.major+1, 0, 0)}}, nil
+		case p.patch < 0:
+			return []comparator{{"<", upperBound(p.major, p.minor+1, 0)}}, nil
+		}
+	case "<":
+		if p.minor < 0 || p.patch < 0 {
+			return []comparator{{"<", upperBound(max(p.major, 0), max(p.minor, 0), 0)}}, nil
+		}
+	}
+	return []comparator{{op, p.floor()}}, nil
+}
+
+// parsePartial parses a version in which parts may be missing or wildcards (x, X, *).
+func parsePartial(version string) (*partial, error) {
+	if version == "" {
+		return &partial{-1, -1, -1, ""}, nil
+	}
+	m := partialRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	p := &partial{-1, -1, -1, m[4]}
+	for i, dst := range []*int{&p.major, &p.minor, &p.patch} {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			break
+		}
+		*dst = n
+	}
+	return p, nil
+}
+
+// floor returns the lowest version matched by the partial version.
+func (p *partial) floor() *Version {
+	v := &Version{Major: uint64(max(p.major, 0)), Minor: uint64(max(p.minor, 0)), Patch: uint64(max(p.patch, 0))}
+	if p.prerelease != "" && p.patch >= 0 {
+		v.Prerelease = strings.Split(p.prerelease, ".")
+	}
+	return v
+}
+
+// upperBound returns an exclusive upper bound excluding prereleases of that version.
+func upperBound(major, minor, patch int) *Version {
+	return &Version{Major: uint64(major), Minor: uint64(minor), Patch: uint64(patch), Prerelease: []string{"0"}}
+}
+
+// Test reports whether the version satisfies the range.
+func (r Range) Test(v *Version) bool {
+	for _, set := range r {
+		if testSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// testSet reports whether the version satisfies every comparator of the set.
+func testSet(set []comparator, v *Version) bool {
+	for _, c := range set {
+		if !c.test(v) {
+			return false
+		}
+	}
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+	// Prereleases only match when a comparator explicitly opts in for the same version tuple
+	for _, c := range set {
+		if len(c.version.Prerelease) == 0 {
+			continue
+		}
+		if c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// test reports whether the version satisfies the comparator.
+func (c comparator) test(v *Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// Satisfies reports whether the version satisfies the npm range.
+func Satisfies(version, rng string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	r, err := ParseRange(rng)
+	if err != nil {
+		return false
+	}
+	return r.Test(v)
+}
+
+// MaxSatisfying returns the highest version satisfying the range or an empty string.
+func MaxSatisfying(versions []string, rng string) string {
+	r, err := ParseRange(rng)
+	if err != nil {
+		return ""
+	}
+	var best *Version
+	bestRaw := ""
+	for _, raw := range versions {
+		v, err := ParseVersion(raw)
+		if err != nil || !r.Test(v) {
+			continue
+		}
+		if best == nil || v.Compare(best) > 0 {
+			best, bestRaw = v, raw
+		}
+	}
+	return bestRaw
+}
+
+// IsValidRange reports whether the string is a valid npm version range.
+func IsValidRange(rng string) bool {
+	_, err := ParseRange(rng)
+	return err == nil
+}
+
+// SortVersions sorts versions in ascending order, invalid versions first.
+func SortVersions(versions []string) {
+	slices.SortFunc(versions, func(a, b string) int {
+		va, errA := ParseVersion(a)
+		vb, errB := ParseVersion(b)
+		switch {
+		case errA != nil && errB != nil:
+			return strings.Compare(a, b)
+		case errA != nil:
+			return -1
+		case errB != nil:
+			return 1
+		}
+		return va.Compare(vb)
+	})
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSatisfies ensures npm ranges are desugared like node-semver does
+func TestSatisfies(t *testing.T) {
+	cases := []struct {
+		version string
+		rng     string
+		want    bool
+	}{
+		{"1.2.3", "^1.2.0", true},
+		{"2.0.0", "^1.2.0", false},
+		{"0.2.5", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"0.0.4", "^0.0.3", false},
+		{"1.2.9", "~1.2.3", true},
+		{"1.3.0", "~1.2.3", false},
+		{"1.9.0", "1.x", true},
+		{"2.0.0", "1.x", false},
+		{"1.5.0", ">=1.0.0 <2.0.0", true},
+		{"2.3.4", "1.2 - 2.3.4", true},
+		{"2.3.5", "1.2 - 2.3.4", false},
+		{"3.0.0", "^1.0.0 || ^3.0.0", true},
+		{"5.0.0", "*", true},
+		{"5.0.0", "", true},
+		{"2.1.0", ">2.0", true},
+		{"2.0.9", ">2.0", false},
+		{"1.2.3-beta.2", "^1.2.3-beta.1", true},
+		{"1.3.0-beta.1", "^1.2.3-beta.1", false},
+		{"1.0.0-rc.1", "*", false},
+		{"1.0.0", "= 1.0.0", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, Satisfies(c.version, c.rng), "%s satisfies %s", c.version, c.rng)
+	}
+}
+
+// TestMaxSatisfying ensures the highest matching version is picked
+func TestMaxSatisfying(t *testing.T) {
+	versions := []string{"1.0.0", "1.2.0", "1.10.0", "2.0.0", "2.1.0-beta.1"}
+	assert.Equal(t, "1.10.0", MaxSatisfying(versions, "^1.0.0"))
+	assert.Equal(t, "2.0.0", MaxSatisfying(versions, ">=1"))
+	assert.Equal(t, "", MaxSatisfying(versions, "^3.0.0"))
+	assert.Equal(t, "", MaxSatisfying(versions, "not a range"))
+}
@@ -0,0 +1,361 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	KEYS_ENDPOINT     = "-/npm/v1/keys"
+	PUBLISH_PREDICATE = "https://github.com/npm/attestation/tree/main/specs/publish/v0.1"
+	DSSE_VERSION      = "DSSEv1"
+)
+
+// Statuses of the signatures and provenance of a package
+const (
+	SIGNATURE_VERIFIED = "verified"
+	SIGNATURE_MISSING  = "missing"
+	SIGNATURE_INVALID  = "invalid"
+)
+
+// Signature is a registry signature of a package version.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Attestations points to the attestations of a package version.
+type Attestations struct {
+	URL        string `json:"url"`
+	Provenance struct {
+		PredicateType string `json:"predicateType"`
+	} `json:"provenance"`
+}
+
+// RegistryKey is a public key the registry signs packages with.
+type RegistryKey struct {
+	KeyID   string `json:"keyid"`
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	// Key is the base64 encoded DER public key
+	Key string `json:"key"`
+	// Expires is the time after which the key no longer signs packages, empty when it is still in use
+	Expires string `json:"expires"`
+}
+
+// SignatureResult is the verification status of a package.
+type SignatureResult struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	// Provenance is the status of the attestations, when they are checked
+	Provenance string `json:"provenance,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// attestationBundle is the response of the attestations endpoint of a package version.
+type attestationBundle struct {
+	Attestations []struct {
+		PredicateType string `json:"predicateType"`
+		Bundle        struct {
+			DSSEEnvelope dsseEnvelope `json:"dsseEnvelope"`
+		} `json:"bundle"`
+	} `json:"attestations"`
+}
+
+// dsseEnvelope is a signed in-toto statement.
+type dsseEnvelope struct {
+	Payload     string      `json:"payload"`
+	PayloadType string      `json:"payloadType"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// inTotoStatement is the payload of an attestation, its subjects are the attested tarballs.
+type inTotoStatement struct {
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+}
+
+// inTotoSubject is an artifact of an in-toto statement, such as pkg:npm/name@version.
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// LoadKeys reads the keys of the registry-keys file when set, or fetches them from the registry.
+func LoadKeys(ctx context.Context, config Config) ([]RegistryKey, error) {
+	if path := config.Get("registry-keys", ""); path != "" {
+		return ReadKeys(path)
+	}
+	return FetchKeys(ctx, config.Registry())
+}
+
+// FetchKeys fetches the signing keys of a registry, none when it does not sign packages.
+func FetchKeys(ctx context.Context, registry string) ([]RegistryKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(registry, "/")+"/"+KEYS_ENDPOINT, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize keys request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, failure(FAILURE_NETWORK, "Failed to fetch the keys of %s: %w", registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure(FAILURE_NETWORK, "Failed to fetch the keys of %s: %s", registry, resp.Status)
+	}
+	var body struct {
+		Keys []RegistryKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Failed to decode the keys of %s: %w", registry, err)
+	}
+	return body.Keys, nil
+}
+
+// ReadKeys reads keys saved in the format of the keys endpoint.
+func ReadKeys(path string) ([]RegistryKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Keys []RegistryKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return body.Keys, nil
+}
+
+// verify checks an ECDSA signature of a message made with the key at a publish time.
+func (k RegistryKey) verify(message []byte, sig string, published string) error {
+	if k.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, k.Expires)
+		if err != nil {
+			return fmt.Errorf("invalid expiry of key %s: %w", k.KeyID, err)
+		}
+		// Signatures made after a key expired are not trusted
+		publishedAt, err := time.Parse(time.RFC3339, published)
+		if err != nil || !publishedAt.Before(expires) {
+			return fmt.Errorf("signed with key %s which expired on %s", k.KeyID, k.Expires)
+		}
+	}
+	der, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return fmt.Errorf("invalid key %s: %w", k.KeyID, err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("invalid key %s: %w", k.KeyID, err)
+	}
+	public, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("key %s is not an ECDSA key", k.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	digest := sha256.Sum256(message)
+	if !ecdsa.VerifyASN1(public, digest[:], signature) {
+		return fmt.Errorf("signature does not match key %s", k.KeyID)
+	}
+	return nil
+}
+
+// verifyWith checks that one of the signatures was made by a key of the registry.
+func verifyWith(keys []RegistryKey, signatures []Signature, message []byte, published string) error {
+	for _, signature := range signatures {
+		i := slices.IndexFunc(keys, func(k RegistryKey) bool { return k.KeyID == signature.KeyID })
+		if i < 0 {
+			continue
+		}
+		return keys[i].verify(message, signature.Sig, published)
+	}
+	return fmt.Errorf("no signature made with a key of the registry")
+}
+
+// VerifyManifest checks the registry signatures of a package version, which sign
+// name@version:integrity.
+func VerifyManifest(manifest *Manifest, published string, keys []RegistryKey) error {
+	message := fmt.Sprintf("%s@%s:%s", manifest.Name, manifest.Version, manifest.Dist.Integrity)
+	return verifyWith(keys, manifest.Dist.Signatures, []byte(message), published)
+}
+
+// VerifyProvenance checks the attestations of a package version: the publish attestation
+// must be signed by the registry and every attestation must be about the tarball of the
+// package. The Sigstore certificate of the build provenance is not verified.
+func VerifyProvenance(ctx context.Context, manifest *Manifest, published string, keys []RegistryKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifest.Dist.Attestations.URL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize attestations request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch attestations: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch attestations: %s", resp.Status)
+	}
+	var bundle attestationBundle
+	if err := json.NewDecoder(resp.Body).Decode(&bundle); err != nil {
+		return fmt.Errorf("Failed to decode attestations: %w", err)
+	}
+
+	digest, err := integrityHex(manifest.Dist.Integrity)
+	if err != nil {
+		return err
+	}
+	predicates := []string{}
+	for _, attestation := range bundle.Attestations {
+		envelope := attestation.Bundle.DSSEEnvelope
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid %s attestation: %w", attestation.PredicateType, err)
+		}
+		var statement inTotoStatement
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			return fmt.Errorf("invalid %s attestation: %w", attestation.PredicateType, err)
+		}
+		if !slices.ContainsFunc(statement.Subject, func(s inTotoSubject) bool { return s.Digest["sha512"] == digest }) {
+			return fmt.Errorf("%s attestation is not about this tarball", statement.PredicateType)
+		}
+		if statement.PredicateType == PUBLISH_PREDICATE {
+			if err := verifyWith(keys, envelope.Signatures, dssePAE(envelope.PayloadType, payload), published); err != nil {
+				return fmt.Errorf("publish attestation: %w", err)
+			}
+		}
+		predicates = append(predicates, statement.PredicateType)
+	}
+	if !slices.Contains(predicates, PUBLISH_PREDICATE) {
+		return fmt.Errorf("no publish attestation")
+	}
+	if predicate := manifest.Dist.Attestations.Provenance.PredicateType; predicate != "" && !slices.Contains(predicates, predicate) {
+		return fmt.Errorf("no %s attestation", predicate)
+	}
+	return nil
+}
+
+// dssePAE returns the pre-authentication encoding of a DSSE payload, the signed message.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "%s %d %s %d %s", DSSE_VERSION, len(payloadType), payloadType, len(payload), payload)
+}
+
+// integrityHex returns the hex encoded sha512 digest of an SRI string.
+func integrityHex(integrity string) (string, error) {
+	for _, entry := range strings.Fields(integrity) {
+		if digest, ok := strings.CutPrefix(entry, "sha512-"); ok {
+			decoded, err := base64.StdEncoding.DecodeString(digest)
+			if err != nil {
+				return "", fmt.Errorf("invalid integrity %q", entry)
+			}
+			return hex.EncodeToString(decoded), nil
+		}
+	}
+	return "", fmt.Errorf("no sha512 integrity to compare attestations with")
+}
+
+// VerifySignatures checks the registry signatures, and the attestations when provenance
+// is set, of the registry packages of the lockfile. fetch gets their package documents.
+func (l *Lockfile) VerifySignatures(ctx context.Context, fetch func(context.Context, string) (*BodyRegistery, error), keys []RegistryKey, provenance bool) ([]SignatureResult, error) {
+	results := []SignatureResult{}
+	var mu sync.Mutex
+	g := errgroup.Group{}
+	g.SetLimit(MAX_CONCURRENT_DOWNLOADS)
+	for key, p := range l.Packages {
+		if key != PackageKey(p.Name, p.Version) {
+			continue
+		}
+		g.Go(func() error {
+			body, err := fetch(ctx, p.Name)
+			if err != nil {
+				return err
+			}
+			result := verifyPackage(ctx, body, p, keys, provenance)
+			result.Package = key
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(results, func(a, b SignatureResult) int {
+		return strings.Compare(a.Package, b.Package)
+	})
+	return results, nil
+}
+
+// verifyPackage checks a locked package against its package document.
+func verifyPackage(ctx context.Context, body *BodyRegistery, p *LockPackage, keys []RegistryKey, provenance bool) SignatureResult {
+	result := SignatureResult{Name: p.Name, Version: p.Version}
+	manifest, ok := body.Versions[p.Version]
+	switch {
+	case !ok:
+		result.Status, result.Error = SIGNATURE_INVALID, "version not found in the registry"
+	case manifest.Dist.Integrity != "" && p.Integrity != "" && manifest.Dist.Integrity != p.Integrity:
+		result.Status, result.Error = SIGNATURE_INVALID, "locked integrity differs from the registry"
+	case len(manifest.Dist.Signatures) == 0:
+		result.Status = SIGNATURE_MISSING
+	default:
+		manifest.Name, manifest.Version = p.Name, p.Version
+		if err := VerifyManifest(&manifest, body.Time[p.Version], keys); err != nil {
+			result.Status, result.Error = SIGNATURE_INVALID, err.Error()
+		} else {
+			result.Status = SIGNATURE_VERIFIED
+		}
+	}
+	if !provenance || result.Status == SIGNATURE_INVALID {
+		return result
+	}
+	if manifest.Dist.Attestations == nil {
+		result.Provenance = SIGNATURE_MISSING
+	} else if err := VerifyProvenance(ctx, &manifest, body.Time[p.Version], keys); err != nil {
+		result.Provenance, result.Error = SIGNATURE_INVALID, err.Error()
+	} else {
+		result.Provenance = SIGNATURE_VERIFIED
+	}
+	return result
+}
+
+// Problem describes why a package failed verification: its signature or attestations
+// are invalid, or it has no signature. It is empty when the package passed.
+func (r SignatureResult) Problem() string {
+	switch {
+	case r.Status == SIGNATURE_MISSING:
+		return "missing registry signature"
+	case r.Status != SIGNATURE_VERIFIED:
+		return "invalid registry signature: " + r.Error
+	case r.Provenance == SIGNATURE_INVALID:
+		return "invalid attestations: " + r.Error
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signer signs messages like the registry does.
+type signer struct {
+	key *ecdsa.PrivateKey
+	id  string
+}
+
+// newSigner generates a P-256 key and returns it along with its public registry key.
+func newSigner(t *testing.T, id, expires string) (*signer, RegistryKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	return &signer{key, id}, RegistryKey{KeyID: id, KeyType: "ecdsa-sha2-nistp256", Key: base64.StdEncoding.EncodeToString(der), Expires: expires}
+}
+
+// sign returns the signature of a message.
+func (s *signer) sign(t *testing.T, message []byte) Signature {
+	digest := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	assert.NoError(t, err)
+	return Signature{KeyID: s.id, Sig: base64.StdEncoding.EncodeToString(sig)}
+}
+
+// TestVerifySignatures ensures registry signatures are checked against the keys and the lockfile
+func TestVerifySignatures(t *testing.T) {
+	current, currentKey := newSigner(t, "SHA256:current", "")
+	old, oldKey := newSigner(t, "SHA256:old", "2023-01-01T00:00:00.000Z")
+	keys := []RegistryKey{currentKey, oldKey}
+
+	published := map[string]string{"1.0.0": "2022-06-01T00:00:00.000Z", "1.1.0": "2024-06-01T00:00:00.000Z"}
+	manifests := map[string]Manifest{}
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+		integrity := "sha512-" + base64.StdEncoding.EncodeToString([]byte("tarball "+version))
+		manifests[version] = Manifest{Name: "a", Version: version, Dist: Dist{Integrity: integrity}}
+	}
+	sign := func(s *signer, version, integrity string) {
+		m := manifests[version]
+		m.Dist.Signatures = []Signature{s.sign(t, fmt.Appendf(nil, "a@%s:%s", version, integrity))}
+		manifests[version] = m
+	}
+	sign(old, "1.0.0", manifests["1.0.0"].Dist.Integrity)     // signed before the key expired
+	sign(old, "1.1.0", manifests["1.1.0"].Dist.Integrity)     // published after the key expired
+	sign(current, "1.2.0", manifests["1.2.0"].Dist.Integrity) // valid
+	sign(current, "1.3.0", "sha512-tampered")                 // signature of another tarball
+	body := &BodyRegistery{Name: "a", Versions: manifests, Time: published}
+
+	lock := NewLockfile()
+	for version, m := range manifests {
+		lock.Packages[PackageKey("a", version)] = &LockPackage{Name: "a", Version: version, Integrity: m.Dist.Integrity}
+	}
+	lock.Packages["a@1.5.0"] = &LockPackage{Name: "a", Version: "1.5.0"}
+	results, err := lock.VerifySignatures(t.Context(), func(context.Context, string) (*BodyRegistery, error) { return body, nil }, keys, false)
+	assert.NoError(t, err)
+
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Version] = result.Status
+	}
+	assert.Equal(t, map[string]string{
+		"1.0.0": SIGNATURE_VERIFIED,
+		"1.1.0": SIGNATURE_INVALID,
+		"1.2.0": SIGNATURE_VERIFIED,
+		"1.3.0": SIGNATURE_INVALID,
+		"1.4.0": SIGNATURE_MISSING,
+		"1.5.0": SIGNATURE_INVALID,
+	}, statuses)
+	assert.Contains(t, results[1].Problem(), "expired")
+	assert.Empty(t, results[2].Problem())
+
+	// The lockfile must point to the signed tarball
+	lock.Packages["a@1.2.0"].Integrity = "sha512-other"
+	results, err = lock.VerifySignatures(t.Context(), func(context.Context, string) (*BodyRegistery, error) { return body, nil }, keys, false)
+	assert.NoError(t, err)
+	assert.Contains(t, results[2].Problem(), "locked integrity differs")
+}
+
+// TestVerifyProvenance ensures the publish attestation is signed by the registry and about the tarball
+func TestVerifyProvenance(t *testing.T) {
+	registry, key := newSigner(t, "SHA256:registry", "")
+	tarball := sha512.Sum512([]byte("tarball"))
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(tarball[:])
+
+	attestation := func(s *signer, digest string) map[string]any {
+		payload, _ := json.Marshal(map[string]any{
+			"_type":         "https://in-toto.io/Statement/v1",
+			"subject":       []map[string]any{{"name": "pkg:npm/a@1.0.0", "digest": map[string]string{"sha512": digest}}},
+			"predicateType": PUBLISH_PREDICATE,
+		})
+		payloadType := "application/vnd.in-toto+json"
+		return map[string]any{
+			"predicateType": PUBLISH_PREDICATE,
+			"bundle": map[string]any{"dsseEnvelope": map[string]any{
+				"payload":     base64.StdEncoding.EncodeToString(payload),
+				"payloadType": payloadType,
+				"signatures":  []Signature{s.sign(t, dssePAE(payloadType, payload))},
+			}},
+		}
+	}
+	other, _ := newSigner(t, "SHA256:registry", "")
+	bundles := map[string]map[string]any{
+		"/valid":     attestation(registry, hex.EncodeToString(tarball[:])),
+		"/forged":    attestation(other, hex.EncodeToString(tarball[:])),
+		"/unrelated": attestation(registry, hex.EncodeToString(make([]byte, 64))),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"attestations": []any{bundles[r.URL.Path]}})
+	}))
+	defer server.Close()
+
+	for path, expected := range map[string]string{"/valid": "", "/forged": "does not match", "/unrelated": "not about this tarball"} {
+		manifest := &Manifest{Name: "a", Version: "1.0.0", Dist: Dist{Integrity: integrity, Attestations: &Attestations{URL: server.URL + path}}}
+		err := VerifyProvenance(t.Context(), manifest, "", []RegistryKey{key})
+		if expected == "" {
+			assert.NoError(t, err, path)
+		} else if assert.Error(t, err, path) {
+			assert.Contains(t, err.Error(), expected, path)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	SPEC_REGISTRY = "registry"
+	SPEC_GIT      = "git"
+	SPEC_FILE     = "file"
+	SPEC_LINK     = "link"
+	SPEC_TARBALL  = "tarball"
+	FILE_PROTOCOL = "file:"
+	NPM_PROTOCOL  = "npm:"
+)
+
+// Spec is a representation of a dependency specifier, as given to gopm add
+// or found in the dependencies of a package.json.
+type Spec struct {
+	Type string
+	// Name is the dependency name, empty when it is only known once fetched
+	Name string
+	// Raw is the specifier without the name, as saved in package.json
+	Raw string
+	// Package is the registry name of registry dependencies, which differs
+	// from Name for aliases (npm:other@1)
+	Package string
+	// Range is the exact version, version range or dist-tag of registry dependencies
+	Range string
+	// URL is the clone URL of git dependencies or the URL of tarball dependencies
+	URL string
+	// Committish is the branch, tag or commit of git dependencies
+	Committish string
+	// SemverRange selects the highest matching tag of git dependencies
+	SemverRange string
+	// Path is the path of file and link dependencies, relative to the project
+	Path string
+}
+
+var (
+	hostedShorthand = regexp.MustCompile(`^(github|gitlab|bitbucket):([^/#\s]+)/([^/#\s]+?)(?:\.git)?(?:#(.*))?$`)
+	githubShorthand = regexp.MustCompile(`^([A-Za-z0-9][\w.-]*)/([\w.-]+?)(?:\.git)?(?:#(.*))?$`)
+	hostedDomains   = map[string]string{"github": "github.com", "gitlab": "gitlab.com", "bitbucket": "bitbucket.org"}
+	packageName     = regexp.MustCompile(`^(?:@[A-Za-z0-9~-][\w.~-]*/)?[A-Za-z0-9~-][\w.~-]*$`)
+	distTag         = regexp.MustCompile(`^[A-Za-z][\w.-]*$`)
+	gitSchemes      = map[string]bool{"https": true, "ssh": true, "git": true, "git+ssh": true, "file": true}
+)
+
+// ParseSpec parses an argument of gopm add such as lodash, react@17, typescript@next,
+// @scope/name@~1.2.0, alias@npm:other@1, github:user/repo#v1.0.0,
+// git+https://host/repo.git#semver:^1.0, file:../lib, link:../lib or an https tarball URL.
+// A name may prefix non-registry specifiers (name@file:../lib).
+func ParseSpec(arg string) (*Spec, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil, fmt.Errorf("empty dependency specifier")
+	}
+	if spec, ok, err := parseExternal(arg); ok {
+		return spec, err
+	}
+
+	// name@<specifier>, the name of scoped packages starts with @
+	name, raw := arg, ""
+	if index := strings.Index(arg[1:], "@"); index >= 0 {
+		name, raw = arg[:index+1], arg[index+2:]
+		if spec, ok, err := parseExternal(raw); ok {
+			if err != nil {
+				return nil, err
+			}
+			spec.Name = name
+			return spec, nil
+		}
+	}
+	spec, err := registrySpec(name, raw)
+	if err != nil {
+		return nil, err
+	}
+	if !packageName.MatchString(spec.Name) {
+		return nil, fmt.Errorf("Invalid package name %q", spec.Name)
+	}
+	if raw == "" {
+		spec.Raw = LATEST_TAG
+	}
+	return spec, nil
+}
+
+// ParseDependency parses the specifier of a dependency declared in a package.json.
+func ParseDependency(name, raw string) (*Spec, error) {
+	if spec, ok, err := parseExternal(raw); ok {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		spec.Name = name
+		return spec, nil
+	}
+	return registrySpec(name, raw)
+}
+
+// registrySpec parses the version, range, dist-tag or alias of a registry dependency.
+func registrySpec(name, raw string) (*Spec, error) {
+	spec := &Spec{Type: SPEC_REGISTRY, Name: name, Package: name, Raw: raw}
+	rng := strings.TrimSpace(raw)
+	if target, ok := strings.CutPrefix(rng, NPM_PROTOCOL); ok {
+		spec.Package, rng = target, ""
+		if index := strings.LastIndex(target, "@"); index > 0 {
+			spec.Package, rng = target[:index], target[index+1:]
+		}
+		if !packageName.MatchString(spec.Package) {
+			return nil, fmt.Errorf("Invalid package name %q in %s", spec.Package, raw)
+		}
+	}
+	if rng == "" {
+		rng = LATEST_TAG
+	}
+	if !distTag.MatchString(rng) && !IsValidRange(rng) {
+		return nil, fmt.Errorf("Invalid version, range or tag %q for %s", rng, name)
+	}
+	spec.Range = rng
+	return spec, nil
+}
+
+// IsExact reports whether a registry specifier selects a single version.
+func (s *Spec) IsExact() bool {
+	_, err := ParseVersion(s.Range)
+	return err == nil
+}
+
+// IsTag reports whether a registry specifier is a dist-tag.
+func (s *Spec) IsTag() bool {
+	return !IsValidRange(s.Range) && distTag.MatchString(s.Range)
+}
+
+// SaveSpecifier returns the specifier saved in package.json once a registry
+// dependency resolved to version. Ranges are kept as given, dist-tags and
+// exact versions are saved as the resolved version behind the save prefix.
+func (s *Spec) SaveSpecifier(version, prefix string) string {
+	saved := s.Range
+	if s.IsTag() || s.IsExact() {
+		saved = prefix + version
+	}
+	if s.Package != s.Name {
+		return NPM_PROTOCOL + s.Package + "@" + saved
+	}
+	return saved
+}
+
+// parseExternal parses specifiers pointing outside the registry, ok is false for registry specifiers.
+func parseExternal(raw string) (*Spec, bool, error) {
+	switch {
+	case strings.HasPrefix(raw, LINK_PROTOCOL):
+		return &Spec{Type: SPEC_LINK, Raw: raw, Path: cleanSpecPath(strings.TrimPrefix(raw, LINK_PROTOCOL))}, true, nil
+	case strings.HasPrefix(raw, FILE_PROTOCOL):
+		return &Spec{Type: SPEC_FILE, Raw: raw, Path: cleanSpecPath(strings.TrimPrefix(raw, FILE_PROTOCOL))}, true, nil
+	case strings.HasPrefix(raw, "./"), strings.HasPrefix(raw, "../"), strings.HasPrefix(raw, "/"), raw == ".", raw == "..":
+		return &Spec{Type: SPEC_FILE, Raw: FILE_PROTOCOL + raw, Path: cleanSpecPath(raw)}, true, nil
+	case strings.HasPrefix(raw, "git+"), strings.HasPrefix(raw, "git://"):
+		url, committish, _ := strings.Cut(strings.TrimPrefix(raw, "git+"), "#")
+		spec, err := gitSpec(raw, url, committish)
+		return spec, true, err
+	case strings.HasPrefix(raw, "http://"), strings.HasPrefix(raw, "https://"):
+		url, committish, _ := strings.Cut(raw, "#")
+		if strings.HasSuffix(url, ".git") {
+			spec, err := gitSpec(raw, url, committish)
+			return spec, true, err
+		}
+		return &Spec{Type: SPEC_TARBALL, Raw: raw, URL: raw}, true, nil
+	}
+	if m := hostedShorthand.FindStringSubmatch(raw); m != nil {
+		spec, err := gitSpec(raw, fmt.Sprintf("https://%s/%s/%s.git", hostedDomains[m[1]], m[2], m[3]), m[4])
+		return spec, true, err
+	}
+	if m := githubShorthand.FindStringSubmatch(raw); m != nil && !strings.HasPrefix(raw, "@") {
+		spec, err := gitSpec(raw, fmt.Sprintf("https://github.com/%s/%s.git", m[1], m[2]), m[3])
+		return spec, true, err
+	}
+	return nil, false, nil
+}
+
+// gitSpec creates a git specifier, #semver:<range> selects a tag by version.
+// The URL is passed to git, so it must not look like an option and must use
+// one of the gitSchemes.
+func gitSpec(raw, url, committish string) (*Spec, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	_, host, _ := strings.Cut(rest, "@")
+	if !ok || !gitSchemes[scheme] || strings.HasPrefix(rest, "-") || strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("Invalid git URL %q, expected a https, ssh, git, git+ssh or file URL", url)
+	}
+	spec := &Spec{Type: SPEC_GIT, Raw: raw, URL: url}
+	if rng, ok := strings.CutPrefix(committish, "semver:"); ok {
+		spec.SemverRange = rng
+	} else {
+		spec.Committish = committish
+	}
+	return spec, nil
+}
+
+// cleanSpecPath normalizes the path of file and link specifiers.
+func cleanSpecPath(p string) string {
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(p)))
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseSpec ensures dependency specifiers are recognized
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want Spec
+	}{
+		{"lodash", Spec{Type: SPEC_REGISTRY, Name: "lodash", Package: "lodash", Range: "latest", Raw: "latest"}},
+		{"react@17", Spec{Type: SPEC_REGISTRY, Name: "react", Package: "react", Range: "17", Raw: "17"}},
+		{"typescript@next", Spec{Type: SPEC_REGISTRY, Name: "typescript", Package: "typescript", Range: "next", Raw: "next"}},
+		{"@scope/name@~1.2.0", Spec{Type: SPEC_REGISTRY, Name: "@scope/name", Package: "@scope/name", Range: "~1.2.0", Raw: "~1.2.0"}},
+		{"@scope/name", Spec{Type: SPEC_REGISTRY, Name: "@scope/name", Package: "@scope/name", Range: "latest", Raw: "latest"}},
+		{"old@npm:@scope/other@^1", Spec{Type: SPEC_REGISTRY, Name: "old", Package: "@scope/other", Range: "^1", Raw: "npm:@scope/other@^1"}},
+		{"github:user/repo#v1.0.0", Spec{Type: SPEC_GIT, Raw: "github:user/repo#v1.0.0", URL: "https://github.com/user/repo.git", Committish: "v1.0.0"}},
+		{"user/repo", Spec{Type: SPEC_GIT, Raw: "user/repo", URL: "https://github.com/user/repo.git"}},
+		{"git+ssh://git@host/repo.git#semver:^1.0", Spec{Type: SPEC_GIT, Raw: "git+ssh://git@host/repo.git#semver:^1.0", URL: "ssh://git@host/repo.git", SemverRange: "^1.0"}},
+		{"https://host/pkg-1.0.0.tgz", Spec{Type: SPEC_TARBALL, Raw: "https://host/pkg-1.0.0.tgz", URL: "https://host/pkg-1.0.0.tgz"}},
+		{"../lib/", Spec{Type: SPEC_FILE, Raw: "file:../lib/", Path: "../lib"}},
+		{"lib@link:./packages/lib", Spec{Type: SPEC_LINK, Name: "lib", Raw: "link:./packages/lib", Path: "packages/lib"}},
+	}
+	for _, tt := range tests {
+		spec, err := ParseSpec(tt.arg)
+		assert.NoError(t, err, tt.arg)
+		assert.Equal(t, tt.want, *spec, tt.arg)
+	}
+}
+
+// TestParseSpecInvalid ensures malformed names and ranges are rejected
+func TestParseSpecInvalid(t *testing.T) {
+	for _, arg := range []string{"react@>=>1", "bad name", "@scope/", "alias@npm:"} {
+		_, err := ParseSpec(arg)
+		assert.Error(t, err, arg)
+	}
+}
+
+// TestParseSpecGitURL ensures git URLs which git would read as an option or with an unexpected scheme are rejected
+func TestParseSpecGitURL(t *testing.T) {
+	for _, raw := range []string{"git+--upload-pack=touch pwned", "git+-c core.sshCommand=x", "git+ext::sh -c touch% pwned", "git+ssh://-oProxyCommand=x/repo.git", "http://host/repo.git", "git+git@host:repo.git"} {
+		_, err := ParseDependency("a", raw)
+		assert.Error(t, err, raw)
+		_, err = ParseSpec("a@" + raw)
+		assert.Error(t, err, raw)
+	}
+	for _, raw := range []string{"git+https://host/repo.git", "git://host/repo.git", "git+ssh://git@host/repo.git", "git+file:///srv/repo", "git+ssh://git@github.com:user/repo.git"} {
+		spec, err := ParseDependency("a", raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, SPEC_GIT, spec.Type, raw)
+	}
+}
+
+// TestSaveSpecifier ensures dist-tags and exact versions are saved as the prefixed resolved version
+func TestSaveSpecifier(t *testing.T) {
+	tests := []struct{ arg, prefix, want string }{
+		{"react", "^", "^18.2.0"},
+		{"react@next", "~", "~18.2.0"},
+		{"react@18.2.0", "", "18.2.0"},
+		{"react@~18.2.0", "^", "~18.2.0"},
+		{"react@npm:preact@10", "^", "npm:preact@10"},
+		{"react@npm:preact", "^", "npm:preact@^18.2.0"},
+	}
+	for _, tt := range tests {
+		spec, err := ParseSpec(tt.arg)
+		assert.NoError(t, err, tt.arg)
+		assert.Equal(t, tt.want, spec.SaveSpecifier("18.2.0", tt.prefix), tt.arg)
+	}
+}
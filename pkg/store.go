@@ -0,0 +1,418 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	STORE_VERSION = "v1"
+	IMPORT_AUTO   = "auto"
+	IMPORT_LINK   = "hardlink"
+	IMPORT_CLONE  = "clone"
+	IMPORT_COPY   = "copy"
+)
+
+// Store is a content-addressable store of extracted package files shared by
+// every project. Projects are populated from it via hardlinks, copy-on-write
+// clones or plain copies.
+type Store struct {
+	Dir          string
+	ImportMethod string
+}
+
+// PackageIndex maps the files of an extracted package to their content in the store.
+type PackageIndex struct {
+	Files map[string]IndexedFile `json:"files"`
+}
+
+// IndexedFile is a representation of a file of a package in the store.
+type IndexedFile struct {
+	Digest string `json:"digest"`
+	Mode   uint32 `json:"mode"`
+	Size   int64  `json:"size"`
+}
+
+// OpenStore opens the store configured by store-dir (default ~/.gopm/store).
+func OpenStore(config Config) (*Store, error) {
+	dir := config.Get("store-dir", "")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate the home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".gopm", "store")
+	}
+	store := &Store{
+		Dir:          filepath.Join(dir, STORE_VERSION),
+		ImportMethod: config.Get("package-import-method", IMPORT_AUTO),
+	}
+	switch store.ImportMethod {
+	case IMPORT_AUTO, IMPORT_LINK, IMPORT_CLONE, IMPORT_COPY:
+	default:
+		return nil, fmt.Errorf("unknown package-import-method %q", store.ImportMethod)
+	}
+	for _, sub := range []string{"files", "index", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(store.Dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create store: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// filePath returns the path of a content file in the store.
+func (s *Store) filePath(digest string, mode uint32) string {
+	name := digest[2:]
+	if mode&0111 != 0 {
+		// Hardlinks share their mode, executables are stored apart
+		name += "-exec"
+	}
+	return filepath.Join(s.Dir, "files", digest[:2], name)
+}
+
+// indexPath returns the path of the index of a package identified by its integrity.
+func (s *Store) indexPath(integrity string) string {
+	sum := sha256.Sum256([]byte(integrity))
+	return filepath.Join(s.Dir, "index", hex.EncodeToString(sum[:])+".json")
+}
+
+// Index returns the index of a package previously imported with this integrity.
+func (s *Store) Index(integrity string) (*PackageIndex, bool) {
+	if integrity == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(s.indexPath(integrity))
+	if err != nil {
+		return nil, false
+	}
+	var index PackageIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, false
+	}
+	// Files may have been removed by a prune since the index was written
+	for _, file := range index.Files {
+		if _, err := os.Stat(s.filePath(file.Digest, file.Mode)); err != nil {
+			return nil, false
+		}
+	}
+	return &index, true
+}
+
+// ImportTarball extracts a package tarball into the store and indexes it under integrity.
+func (s *Store) ImportTarball(ctx context.Context, tarball, integrity string) (*PackageIndex, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, failure(FAILURE_EXTRACTION, "failed to unzip %s: %w", tarball, err)
+	}
+	defer gz.Close()
+
+	index := &PackageIndex{Files: map[string]IndexedFile{}}
+	reader := tar.NewReader(gz)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, failure(FAILURE_EXTRACTION, "failed to unzip %s: %w", tarball, err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name, ok := tarballEntry(header.Name)
+		if !ok {
+			continue
+		}
+		file, err := s.writeFile(reader, uint32(header.Mode))
+		if err != nil {
+			return nil, failure(FAILURE_EXTRACTION, "failed to extract %s: %w", header.Name, err)
+		}
+		index.Files[name] = file
+	}
+
+	if integrity != "" {
+		if err := s.writeIndex(integrity, index); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// tarballEntry strips the top-level folder (usually package/) from a tarball
+// entry and rejects entries escaping the package folder.
+func tarballEntry(name string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "/"))
+	_, name, ok := strings.Cut(name, "/")
+	if !ok || name == "" || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// writeFile writes content to the store unless it is already there.
+func (s *Store) writeFile(r io.Reader, mode uint32) (IndexedFile, error) {
+	temp, err := os.CreateTemp(filepath.Join(s.Dir, "tmp"), "file-*")
+	if err != nil {
+		return IndexedFile{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	h := sha512.New()
+	size, err := io.Copy(io.MultiWriter(temp, h), r)
+	if err != nil {
+		return IndexedFile{}, err
+	}
+	if err := temp.Close(); err != nil {
+		return IndexedFile{}, err
+	}
+
+	file := IndexedFile{Digest: hex.EncodeToString(h.Sum(nil)), Mode: 0644, Size: size}
+	if mode&0111 != 0 {
+		file.Mode = 0755
+	}
+	target := s.filePath(file.Digest, file.Mode)
+	if _, err := os.Stat(target); err == nil {
+		return file, nil
+	}
+	if err := os.Chmod(temp.Name(), fs.FileMode(file.Mode)); err != nil {
+		return IndexedFile{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return IndexedFile{}, err
+	}
+	return file, os.Rename(temp.Name(), target)
+}
+
+// writeIndex writes the index of a package atomically.
+func (s *Store) writeIndex(integrity string, index *PackageIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexPath := s.indexPath(integrity)
+	tempFilePath := indexPath + ".tmp"
+	if err := os.WriteFile(tempFilePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write store index: %w", err)
+	}
+	return os.Rename(tempFilePath, indexPath)
+}
+
+// Link populates dest with the files of an indexed package.
+func (s *Store) Link(index *PackageIndex, dest string) error {
+	for name, file := range index.Files {
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := s.importFile(s.filePath(file.Digest, file.Mode), target, fs.FileMode(file.Mode)); err != nil {
+			return fmt.Errorf("failed to import %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// importFile imports a store file into a project using the configured method.
+func (s *Store) importFile(src, dst string, mode fs.FileMode) error {
+	switch s.ImportMethod {
+	case IMPORT_LINK:
+		return os.Link(src, dst)
+	case IMPORT_CLONE:
+		return cloneFile(src, dst)
+	case IMPORT_COPY:
+		return copyFile(src, dst, mode)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	if err := cloneFile(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst, mode)
+}
+
+// copyFile copies a file.
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// PruneResult is a summary of a store prune.
+type PruneResult struct {
+	Files   int
+	Indexes int
+	Bytes   int64
+}
+
+// RegisterProject records a project populated from the store, so that prune keeps
+// the files of its locked packages even when they are not hardlinked into it.
+func (s *Store) RegisterProject(dir string) error {
+	sum := sha256.Sum256([]byte(dir))
+	registration := filepath.Join(s.Dir, "projects", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(filepath.Dir(registration), 0755); err != nil {
+		return fmt.Errorf("failed to register project: %w", err)
+	}
+	return os.WriteFile(registration, []byte(dir), 0644)
+}
+
+// referenced returns the store files of the packages locked by the registered
+// projects. Projects which do not exist anymore are forgotten.
+func (s *Store) referenced() (map[string]bool, error) {
+	files := map[string]bool{}
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "projects"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		registration := filepath.Join(s.Dir, "projects", entry.Name())
+		data, err := os.ReadFile(registration)
+		if err != nil {
+			return nil, err
+		}
+		dir := string(data)
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			os.Remove(registration)
+			continue
+		}
+		lock, err := readLockfile(filepath.Join(dir, LOCK_FILE))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the lockfile of %s: %w", dir, err)
+		}
+		if lock == nil {
+			continue
+		}
+		for _, p := range lock.Packages {
+			index, ok := s.Index(p.storeKey())
+			if !ok {
+				continue
+			}
+			for _, file := range index.Files {
+				files[s.filePath(file.Digest, file.Mode)] = true
+			}
+		}
+	}
+	return files, nil
+}
+
+// Prune removes the files no project uses anymore and the indexes referencing them.
+// Link counts tell which files are used when packages are hardlinked. Copied and
+// cloned files are not linked to the store, so the files of the packages locked by
+// registered projects are kept instead, auto relying on both.
+func (s *Store) Prune() (*PruneResult, error) {
+	result := &PruneResult{}
+	used := map[string]bool{}
+	if s.ImportMethod != IMPORT_LINK {
+		var err error
+		if used, err = s.referenced(); err != nil {
+			return nil, fmt.Errorf("failed to list the projects using the store: %w", err)
+		}
+	}
+	err := filepath.WalkDir(filepath.Join(s.Dir, "files"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || used[p] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if s.ImportMethod == IMPORT_LINK || s.ImportMethod == IMPORT_AUTO {
+			if links, ok := linkCount(p, info); !ok || links > 1 {
+				return nil
+			}
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		result.Files++
+		result.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune store files: %w", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "index"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune store indexes: %w", err)
+	}
+	for _, entry := range entries {
+		indexPath := filepath.Join(s.Dir, "index", entry.Name())
+		if s.complete(indexPath) {
+			continue
+		}
+		if err := os.Remove(indexPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		result.Indexes++
+	}
+	return result, nil
+}
+
+// complete reports whether every file of an index is still in the store.
+func (s *Store) complete(indexPath string) bool {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return false
+	}
+	var index PackageIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return false
+	}
+	for _, file := range index.Files {
+		if _, err := os.Stat(s.filePath(file.Digest, file.Mode)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// PackageSize returns the size of the files of a package in the store.
+func (s *Store) PackageSize(p *LockPackage) (int64, bool) {
+	index, ok := s.Index(p.storeKey())
+	if !ok {
+		return 0, false
+	}
+	var size int64
+	for _, file := range index.Files {
+		size += file.Size
+	}
+	return size, true
+}
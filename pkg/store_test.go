@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeTarball builds a gzipped package tarball with files under package/.
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: "package/" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		assert.NoError(t, err, "should write tar header")
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err, "should write tar content")
+	}
+	assert.NoError(t, tw.Close(), "should close tar writer")
+	assert.NoError(t, gz.Close(), "should close gzip writer")
+	return buf.Bytes()
+}
+
+// TestStoreLinkAndPrune ensures packages are hardlinked from the store and unreferenced content is pruned
+func TestStoreLinkAndPrune(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("link counts are not available on Windows")
+	}
+	dir := t.TempDir()
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store"), "package-import-method": IMPORT_LINK})
+	assert.NoError(t, err, "should open store")
+
+	tarball := filepath.Join(dir, "pkg.tgz")
+	files := map[string]string{"package.json": `{"name":"a","version":"1.0.0"}`, "lib/index.js": "module.exports = 1\n", "../evil": "x"}
+	assert.NoError(t, os.WriteFile(tarball, makeTarball(t, files), 0644))
+
+	index, err := store.ImportTarball(t.Context(), tarball, "sha512-test")
+	assert.NoError(t, err, "should import tarball")
+	assert.Len(t, index.Files, 2, "entries escaping the package folder should be ignored")
+
+	_, ok := store.Index("sha512-test")
+	assert.True(t, ok, "package should be indexed")
+
+	dest := filepath.Join(dir, "project", "node_modules", "a")
+	assert.NoError(t, store.Link(index, dest), "should link package")
+	assert.Equal(t, "1.0.0", InstalledVersion(dest))
+	info, err := os.Stat(filepath.Join(dest, "lib", "index.js"))
+	assert.NoError(t, err)
+	links, _ := linkCount(filepath.Join(dest, "lib", "index.js"), info)
+	assert.Equal(t, uint64(2), links, "file should be hardlinked from the store")
+
+	result, err := store.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Files, "linked files should be kept")
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "project")))
+	result, err = store.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Files, "unreferenced files should be removed")
+	assert.Equal(t, 1, result.Indexes, "incomplete indexes should be removed")
+	_, ok = store.Index("sha512-test")
+	assert.False(t, ok, "package should not be indexed anymore")
+}
+
+// TestStorePruneCopy ensures copied packages are kept while a registered project locks them
+func TestStorePruneCopy(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store"), "package-import-method": IMPORT_COPY})
+	assert.NoError(t, err, "should open store")
+
+	for name, integrity := range map[string]string{"a": "sha512-a", "b": "sha512-b"} {
+		tarball := filepath.Join(dir, name+".tgz")
+		assert.NoError(t, os.WriteFile(tarball, makeTarball(t, map[string]string{"package.json": `{"name":"` + name + `","version":"1.0.0"}`}), 0644))
+		_, err := store.ImportTarball(t.Context(), tarball, integrity)
+		assert.NoError(t, err, "should import tarball")
+	}
+	project := filepath.Join(dir, "project")
+	index, _ := store.Index("sha512-a")
+	assert.NoError(t, store.Link(index, filepath.Join(project, "node_modules", "a")), "should copy package")
+	lock := NewLockfile()
+	lock.Packages["a@1.0.0"] = &LockPackage{Name: "a", Version: "1.0.0", Integrity: "sha512-a"}
+	data, err := json.Marshal(lock)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(project, LOCK_FILE), data, 0644))
+	assert.NoError(t, store.RegisterProject(project))
+
+	result, err := store.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Files, "only the package no project locks should be removed")
+	_, ok := store.Index("sha512-a")
+	assert.True(t, ok, "copied package should be kept")
+	_, ok = store.Index("sha512-b")
+	assert.False(t, ok)
+
+	assert.NoError(t, os.RemoveAll(project))
+	result, err = store.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Files, "packages of removed projects should be removed")
+	entries, err := os.ReadDir(filepath.Join(store.Dir, "projects"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "removed projects should be forgotten")
+}
+
+// TestInstallPackageChanged ensures a package is reinstalled when its content changes under the same version
+func TestInstallPackageChanged(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	store, err := OpenStore(Config{"store-dir": filepath.Join(dir, "store"), "package-import-method": IMPORT_COPY})
+	assert.NoError(t, err, "should open store")
+
+	// Two commits of a git dependency with the same version
+	install := func(commit, content string) []string {
+		source := filepath.Join(dir, "source-"+commit)
+		assert.NoError(t, os.MkdirAll(source, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(source, PACKAGE_JSON), []byte(`{"name":"a","version":"1.0.0"}`), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(source, "index.js"), []byte(content), 0644))
+		p := &LockPackage{Name: "a", Version: "1.0.0", Resolved: "git+https://host/a.git#" + commit}
+		_, err := store.ImportDir(t.Context(), source, p.Resolved)
+		assert.NoError(t, err)
+
+		tx, err := BeginTransaction()
+		assert.NoError(t, err)
+		staged, err := InstallPackage(t.Context(), tx, store, p, []string{"node_modules/a"}, false)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Swap())
+		assert.NoError(t, tx.Commit())
+		return staged
+	}
+	assert.Len(t, install("aaaaaaa", "1"), 1)
+	assert.Empty(t, install("aaaaaaa", "1"), "the same commit is left untouched")
+	assert.Len(t, install("bbbbbbb", "2"), 1, "another commit is installed")
+	data, err := os.ReadFile(filepath.Join(dir, "node_modules", "a", "index.js"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(data))
+}
@@ -0,0 +1,268 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	STAGING_DIR  = ".gopm-staging"
+	JOURNAL_FILE = "journal"
+)
+
+// ErrInterrupted is returned by the operations of an interrupted transaction.
+var ErrInterrupted = errors.New("install interrupted")
+
+// Transaction stages the packages of an install and journals every path it replaces,
+// so that node_modules, package.json and the lockfile can be rolled back when the
+// install fails or is interrupted. Its files live in node_modules/.gopm-staging, on
+// the filesystem of the project so that swapping packages in and out are renames.
+type Transaction struct {
+	dir     string
+	journal *os.File
+	changes []change
+	// staged maps node_modules locations to the directory staging their package
+	staged      map[string]string
+	count       int
+	mu          sync.Mutex
+	interrupted atomic.Bool
+}
+
+// change records that Path was replaced, its previous content being moved to
+// Moved. Moved is empty when nothing lived at Path.
+type change struct {
+	Path  string `json:"path"`
+	Moved string `json:"moved,omitempty"`
+}
+
+// BeginTransaction starts a transaction in the project. The transaction of an install
+// which crashed before committing or rolling back is rolled back first.
+func BeginTransaction() (*Transaction, error) {
+	dir := filepath.Join(GetCwd(), NODE_MODULE, STAGING_DIR)
+	if err := recoverTransaction(dir); err != nil {
+		return nil, fmt.Errorf("failed to roll back the interrupted install: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	journal, err := os.Create(filepath.Join(dir, JOURNAL_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the install journal: %w", err)
+	}
+	return &Transaction{dir: dir, journal: journal, staged: map[string]string{}}, nil
+}
+
+// recoverTransaction rolls back the changes journaled in a leftover staging directory.
+func recoverTransaction(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, JOURNAL_FILE))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Without journal, the transaction was committed or had not changed anything
+		return os.RemoveAll(dir)
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Warnln("Rolling back an interrupted install")
+	t := &Transaction{dir: dir}
+	for _, line := range strings.Split(string(data), "\n") {
+		var c change
+		if json.Unmarshal([]byte(line), &c) == nil {
+			t.changes = append(t.changes, c)
+		}
+	}
+	return t.Rollback()
+}
+
+// Interrupt makes the pending and future operations of the transaction fail with ErrInterrupted.
+func (t *Transaction) Interrupt() {
+	t.interrupted.Store(true)
+}
+
+// Err returns ErrInterrupted once the transaction is interrupted.
+func (t *Transaction) Err() error {
+	if t.interrupted.Load() {
+		return ErrInterrupted
+	}
+	return nil
+}
+
+// tempPath returns a new path in the staging directory.
+func (t *Transaction) tempPath(prefix string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+	return filepath.Join(t.dir, prefix+strconv.Itoa(t.count))
+}
+
+// record journals a change before it is made, so that a crash in between leaves
+// enough information to undo it.
+func (t *Transaction) record(c change) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := t.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write the install journal: %w", err)
+	}
+	t.changes = append(t.changes, c)
+	return nil
+}
+
+// Stage returns an empty directory in which the package of a node_modules location,
+// relative to the project, is prepared. Staged packages are moved into place by Swap.
+func (t *Transaction) Stage(location string) (string, error) {
+	if err := t.Err(); err != nil {
+		return "", err
+	}
+	dir := t.tempPath("staged-")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	t.staged[location] = dir
+	t.mu.Unlock()
+	return dir, nil
+}
+
+// Unstage discards the packages staged for node_modules locations.
+func (t *Transaction) Unstage(locations ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, location := range locations {
+		if dir, ok := t.staged[location]; ok {
+			os.RemoveAll(dir)
+			delete(t.staged, location)
+		}
+	}
+}
+
+// Replace moves what lives at path into the transaction so that the caller can create
+// it afresh. Regular files are copied instead, staying in place until overwritten.
+func (t *Transaction) Replace(path string) error {
+	_, err := t.replace(path)
+	return err
+}
+
+// replace replaces path and returns where its previous content was moved, empty
+// when nothing lived at path.
+func (t *Transaction) replace(path string) (string, error) {
+	if err := t.Err(); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", t.record(change{Path: path})
+	}
+	if err != nil {
+		return "", err
+	}
+	moved := t.tempPath("replaced-")
+	if err := t.record(change{Path: path, Moved: moved}); err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return moved, os.Rename(path, moved)
+	}
+	// The copy only appears under its journaled name once complete
+	if err := copyFile(path, moved+".tmp", info.Mode()); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	return moved, os.Rename(moved+".tmp", moved)
+}
+
+// Swap moves the staged packages into node_modules, parents before the packages
+// nested in them. The node_modules folder nested in a replaced package is kept for
+// the packages which did not change, the others being removed with RemoveExtraneous.
+func (t *Transaction) Swap() error {
+	cwd := GetCwd()
+	for _, location := range slices.Sorted(maps.Keys(t.staged)) {
+		target := filepath.Join(cwd, filepath.FromSlash(location))
+		previous, err := t.replace(target)
+		if err != nil {
+			return fmt.Errorf("failed to replace %s: %w", location, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		staged := t.staged[location]
+		if err := os.Rename(staged, target); err != nil {
+			return fmt.Errorf("failed to install %s: %w", location, err)
+		}
+		delete(t.staged, location)
+
+		// Carry the packages nested in the previous version over, unless it was a link
+		nested := filepath.Join(previous, NODE_MODULE)
+		if info, err := os.Lstat(previous); previous == "" || err != nil || !info.IsDir() {
+			continue
+		}
+		if _, err := os.Lstat(nested); err != nil {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(target, NODE_MODULE)); err == nil {
+			continue
+		}
+		if err := t.record(change{Path: nested, Moved: filepath.Join(target, NODE_MODULE)}); err != nil {
+			return err
+		}
+		if err := os.Rename(nested, filepath.Join(target, NODE_MODULE)); err != nil {
+			return fmt.Errorf("failed to keep the dependencies of %s: %w", location, err)
+		}
+	}
+	return nil
+}
+
+// Commit keeps the changes of the transaction and removes what they replaced.
+func (t *Transaction) Commit() error {
+	t.journal.Close()
+	// Removing the journal first commits the transaction even if the removal is cut short
+	if err := os.Remove(filepath.Join(t.dir, JOURNAL_FILE)); err != nil {
+		return err
+	}
+	return os.RemoveAll(t.dir)
+}
+
+// Rollback undoes the changes of the transaction in reverse order. Changes whose
+// journal entry was written but which were not made yet are skipped.
+func (t *Transaction) Rollback() error {
+	if t.journal != nil {
+		t.journal.Close()
+	}
+	errs := []error{}
+	for _, c := range slices.Backward(t.changes) {
+		if c.Moved != "" {
+			if _, err := os.Lstat(c.Moved); err != nil {
+				continue
+			}
+		}
+		if err := os.RemoveAll(c.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if c.Moved != "" {
+			if err := os.Rename(c.Moved, c.Path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(t.dir, JOURNAL_FILE)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(t.dir)
+}
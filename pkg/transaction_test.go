@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTransaction ensures installs change node_modules and project files only when they commit
+func TestTransaction(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	write := func(path, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0644))
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	write(PACKAGE_JSON, "before")
+	write("node_modules/a/index.js", "a@1")
+	write("node_modules/a/node_modules/b/index.js", "b@1")
+
+	change := func(tx *Transaction) {
+		for location, content := range map[string]string{"node_modules/a": "a@2", "node_modules/c": "c@1"} {
+			staged, err := tx.Stage(location)
+			assert.NoError(t, err)
+			assert.NoError(t, os.WriteFile(filepath.Join(staged, "index.js"), []byte(content), 0644))
+		}
+		assert.NoError(t, tx.Swap())
+		assert.NoError(t, tx.Replace(filepath.Join(dir, PACKAGE_JSON)))
+		write(PACKAGE_JSON, "after")
+	}
+
+	tx, err := BeginTransaction()
+	assert.NoError(t, err)
+	change(tx)
+	assert.Equal(t, "a@2", read("node_modules/a/index.js"))
+	assert.Equal(t, "b@1", read("node_modules/a/node_modules/b/index.js"), "packages nested in a replaced package are kept")
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, "a@1", read("node_modules/a/index.js"))
+	assert.Equal(t, "b@1", read("node_modules/a/node_modules/b/index.js"))
+	assert.NoDirExists(t, filepath.Join(dir, "node_modules/c"))
+	assert.Equal(t, "before", read(PACKAGE_JSON))
+	assert.NoDirExists(t, filepath.Join(dir, NODE_MODULE, STAGING_DIR))
+
+	// A transaction left behind by a crash is rolled back by the next one
+	tx, err = BeginTransaction()
+	assert.NoError(t, err)
+	change(tx)
+	tx.journal.Close()
+	tx, err = BeginTransaction()
+	assert.NoError(t, err)
+	assert.Equal(t, "a@1", read("node_modules/a/index.js"))
+	assert.Equal(t, "before", read(PACKAGE_JSON))
+
+	change(tx)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, "a@2", read("node_modules/a/index.js"))
+	assert.Equal(t, "c@1", read("node_modules/c/index.js"))
+	assert.Equal(t, "after", read(PACKAGE_JSON))
+	assert.NoDirExists(t, filepath.Join(dir, NODE_MODULE, STAGING_DIR))
+
+	tx, err = BeginTransaction()
+	assert.NoError(t, err)
+	tx.Interrupt()
+	_, err = tx.Stage("node_modules/d")
+	assert.ErrorIs(t, err, ErrInterrupted)
+	assert.NoError(t, tx.Rollback())
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"slices"
+	"strings"
+)
+
+// MAX_WHY_PATHS limits the paths explained per package, which can grow exponentially.
+const MAX_WHY_PATHS = 1000
+
+// WhyStep is an edge of a dependency path.
+type WhyStep struct {
+	Name      string `json:"name"`
+	Specifier string `json:"specifier"`
+	Package   string `json:"package"`
+	Version   string `json:"version"`
+	// Type is the section declaring the edge, such as devDependencies
+	Type string `json:"type"`
+}
+
+// WhyPath is a path from an importer to a package.
+type WhyPath struct {
+	Importer string    `json:"importer"`
+	Steps    []WhyStep `json:"steps"`
+}
+
+// WhyResult explains why a package is part of the dependency graph.
+type WhyResult struct {
+	Package string    `json:"package"`
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Paths   []WhyPath `json:"paths"`
+}
+
+// whyParent is a reverse edge of the dependency graph.
+type whyParent struct {
+	// from is the key of the dependent package, or the importer when fromImporter is set
+	from         string
+	fromImporter bool
+	step         WhyStep
+}
+
+// Why returns every path from the importers to the packages matching a query
+// such as lodash, lodash@4.17.21 or lodash@^4, sorted by package.
+func (l *Lockfile) Why(query string) ([]WhyResult, error) {
+	keys, err := l.Match(query)
+	if err != nil {
+		return nil, err
+	}
+	return l.Explain(keys), nil
+}
+
+// Explain returns every path from the importers to the packages with the given keys.
+func (l *Lockfile) Explain(keys []string) []WhyResult {
+	// Index the dependents of every package
+	parents := map[string][]whyParent{}
+	for dir, importer := range l.Importers {
+		for section, edges := range importer.sections() {
+			for depName, dep := range edges {
+				parents[dep.Package] = append(parents[dep.Package], whyParent{dir, true, l.whyStep(depName, dep, section)})
+			}
+		}
+	}
+	for key, p := range l.Packages {
+		for section, edges := range map[string]map[string]LockDependency{DEPENDENCIES: p.Dependencies, OPTIONAL_DEPENDENCIES: p.OptionalDependencies} {
+			for depName, dep := range edges {
+				parents[dep.Package] = append(parents[dep.Package], whyParent{key, false, l.whyStep(depName, dep, section)})
+			}
+		}
+	}
+
+	results := []WhyResult{}
+	for _, key := range keys {
+		p := l.Packages[key]
+		result := WhyResult{Package: key, Name: p.Name, Version: p.Version, Paths: []WhyPath{}}
+		walkParents(parents, key, []WhyStep{}, map[string]bool{key: true}, &result.Paths)
+		slices.SortFunc(result.Paths, func(a, b WhyPath) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		results = append(results, result)
+	}
+	return results
+}
+
+// walkParents collects the paths leading to key, skipping cycles.
+func walkParents(parents map[string][]whyParent, key string, steps []WhyStep, seen map[string]bool, paths *[]WhyPath) {
+	for _, parent := range parents[key] {
+		if len(*paths) >= MAX_WHY_PATHS {
+			return
+		}
+		path := append([]WhyStep{parent.step}, steps...)
+		if parent.fromImporter {
+			*paths = append(*paths, WhyPath{Importer: parent.from, Steps: path})
+			continue
+		}
+		if seen[parent.from] {
+			continue
+		}
+		seen[parent.from] = true
+		walkParents(parents, parent.from, path, seen, paths)
+		delete(seen, parent.from)
+	}
+}
+
+// whyStep describes an edge of the dependency graph.
+func (l *Lockfile) whyStep(name string, dep LockDependency, section string) WhyStep {
+	step := WhyStep{Name: name, Specifier: dep.Specifier, Package: dep.Package, Type: section}
+	if p, ok := l.Packages[dep.Package]; ok {
+		step.Version = p.Version
+	}
+	return step
+}
+
+// sections returns the direct dependencies of an importer by section.
+func (i *LockImporter) sections() map[string]map[string]LockDependency {
+	return map[string]map[string]LockDependency{
+		DEPENDENCIES:          i.Dependencies,
+		DEV_DEPENDENCIES:      i.DevDependencies,
+		OPTIONAL_DEPENDENCIES: i.OptionalDependencies,
+		PEER_DEPENDENCIES:     i.PeerDependencies,
+	}
+}
+
+// String formats a path as importer > name@specifier (version) > ...
+func (p WhyPath) String() string {
+	var b strings.Builder
+	b.WriteString(p.Importer)
+	for i, step := range p.Steps {
+		b.WriteString(" > ")
+		b.WriteString(step.Name + "@" + step.Specifier)
+		if step.Version != "" && step.Version != step.Specifier {
+			b.WriteString(" (" + step.Version + ")")
+		}
+		if i == 0 && step.Type != DEPENDENCIES {
+			b.WriteString(" [" + step.Type + "]")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWhy ensures every path leading to a package is reported with its ranges
+func TestWhy(t *testing.T) {
+	registry := fakeRegistry{
+		"a": {"1.0.0": {"c": "^1.0.0"}},
+		"b": {"1.0.0": {"a": "^1.0.0", "c": "~1.0.0"}},
+		"c": {"1.0.0": nil},
+	}
+	lock := registry.resolve(t, &PackageJSON{
+		Dependencies:    map[string]string{"a": "^1.0.0"},
+		DevDependencies: map[string]string{"b": "^1.0.0"},
+	})
+
+	results, err := lock.Why("c")
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	paths := []string{}
+	for _, path := range results[0].Paths {
+		paths = append(paths, path.String())
+	}
+	assert.Equal(t, []string{
+		". > a@^1.0.0 (1.0.0) > c@^1.0.0 (1.0.0)",
+		". > b@^1.0.0 (1.0.0) [devDependencies] > a@^1.0.0 (1.0.0) > c@^1.0.0 (1.0.0)",
+		". > b@^1.0.0 (1.0.0) [devDependencies] > c@~1.0.0 (1.0.0)",
+	}, paths)
+
+	_, err = lock.Why("c@^2.0.0")
+	assert.Error(t, err, "no version satisfies the range")
+}
@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const WORKSPACE_PROTOCOL = "workspace:"
+
+// Workspaces is a representation of the workspaces field of package.json,
+// either an array of globs or an object with a packages array of globs.
+type Workspaces struct {
+	Packages []string
+	object   bool
+}
+
+// Workspace is a package of a monorepo.
+type Workspace struct {
+	// Dir is the slash separated path of the workspace relative to the root
+	Dir      string
+	Manifest *PackageJSON
+}
+
+// UnmarshalJSON decodes both forms of the workspaces field.
+func (w *Workspaces) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &w.Packages); err == nil {
+		return nil
+	}
+	var object struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("workspaces should be an array of globs: %w", err)
+	}
+	w.Packages, w.object = object.Packages, true
+	return nil
+}
+
+// MarshalJSON encodes the workspaces field in the form it was read.
+func (w Workspaces) MarshalJSON() ([]byte, error) {
+	if w.object {
+		return json.Marshal(map[string][]string{"packages": w.Packages})
+	}
+	return json.Marshal(w.Packages)
+}
+
+// ReadManifest reads the package.json file of a directory.
+func ReadManifest(dir string) (*PackageJSON, error) {
+	file, err := os.Open(filepath.Join(dir, PACKAGE_JSON))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var p PackageJSON
+	if err := json.NewDecoder(file).Decode(&p); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", filepath.Join(dir, PACKAGE_JSON), err)
+	}
+	return &p, nil
+}
+
+// FindWorkspaces returns the workspace packages matched by the globs of the
+// root package.json, sorted by directory. Globs prefixed by ! exclude packages.
+func (p *PackageJSON) FindWorkspaces() ([]Workspace, error) {
+	if p.Workspaces == nil {
+		return nil, nil
+	}
+	cwd := GetCwd()
+	dirs, excluded := []string{}, []string{}
+	for _, pattern := range p.Workspaces.Packages {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			excluded = append(excluded, path.Clean(negated))
+			continue
+		}
+		matches, err := globWorkspace(cwd, path.Clean(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace pattern %q: %w", pattern, err)
+		}
+		dirs = append(dirs, matches...)
+	}
+	slices.Sort(dirs)
+	dirs = slices.Compact(dirs)
+
+	workspaces := []Workspace{}
+	names := map[string]string{}
+	for _, dir := range dirs {
+		if slices.ContainsFunc(excluded, func(pattern string) bool {
+			matched, _ := path.Match(pattern, dir)
+			return matched
+		}) {
+			continue
+		}
+		manifest, err := ReadManifest(filepath.Join(cwd, filepath.FromSlash(dir)))
+		if err != nil {
+			return nil, err
+		}
+		if manifest.Name == "" {
+			return nil, fmt.Errorf("workspace %s has no name in its package.json", dir)
+		}
+		if other, ok := names[manifest.Name]; ok {
+			return nil, fmt.Errorf("workspaces %s and %s are both named %s", other, dir, manifest.Name)
+		}
+		names[manifest.Name] = dir
+		workspaces = append(workspaces, Workspace{Dir: dir, Manifest: manifest})
+	}
+	return workspaces, nil
+}
+
+// globWorkspace returns the directories containing a package.json matched by
+// a pattern. A trailing /** matches every nested directory.
+func globWorkspace(root, pattern string) ([]string, error) {
+	if base, ok := strings.CutSuffix(pattern, "/**"); ok {
+		dirs := []string{}
+		err := filepath.WalkDir(filepath.Join(root, filepath.FromSlash(base)), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && d.Name() == NODE_MODULE {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && d.Name() == PACKAGE_JSON {
+				if rel, err := filepath.Rel(root, filepath.Dir(p)); err == nil && rel != "." {
+					dirs = append(dirs, filepath.ToSlash(rel))
+				}
+			}
+			return nil
+		})
+		return dirs, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(pattern), PACKAGE_JSON))
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for _, match := range matches {
+		if rel, err := filepath.Rel(root, filepath.Dir(match)); err == nil && !strings.Contains(rel, NODE_MODULE) {
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+	}
+	return dirs, nil
+}
+
+// FindWorkspace returns the workspace matching a package name or a directory.
+func FindWorkspace(workspaces []Workspace, nameOrDir string) (*Workspace, error) {
+	dir := path.Clean(filepath.ToSlash(nameOrDir))
+	for i, w := range workspaces {
+		if w.Manifest.Name == nameOrDir || w.Dir == dir {
+			return &workspaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("No workspace named %s", nameOrDir)
+}
+
+// linkTarget returns the location a link package points to.
+func (p *LockPackage) linkTarget() (string, bool) {
+	return strings.CutPrefix(p.Resolved, LINK_PROTOCOL)
+}
+
+// importerLocation returns the location of an importer relative to the project.
+func importerLocation(importer string) string {
+	if importer == ROOT_IMPORTER {
+		return ""
+	}
+	return importer
+}